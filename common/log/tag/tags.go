@@ -195,6 +195,11 @@ func WorkflowEventID(eventID int64) Tag {
 	return newInt64("wf-history-event-id", eventID)
 }
 
+// WorkflowEventType returns tag for WorkflowEventType
+func WorkflowEventType(eventType enumspb.EventType) Tag {
+	return newStringTag("wf-history-event-type", eventType.String())
+}
+
 // WorkflowScheduleID returns tag for WorkflowScheduleID
 func WorkflowScheduleID(scheduleID int64) Tag {
 	return newInt64("wf-schedule-id", scheduleID)
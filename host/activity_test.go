@@ -26,11 +26,13 @@ package host
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"math/rand"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/pborman/uuid"
@@ -1334,3 +1336,537 @@ func (s *integrationSuite) TestActivityCancellationNotStarted() {
 	_, err = poller.PollAndProcessDecisionTask(false, false)
 	s.True(err == nil || err == matching.ErrNoTasks)
 }
+
+func (s *integrationSuite) TestActivityHeartbeatCancellation() {
+	s.testActivityHeartbeatCancellation(false)
+}
+
+func (s *integrationSuite) TestActivityHeartbeatCancellationWithID() {
+	s.testActivityHeartbeatCancellation(true)
+}
+
+func (s *integrationSuite) testActivityHeartbeatCancellation(pollWithID bool) {
+	id := "integration-activity-heartbeat-cancellation-test-" + strconv.FormatBool(pollWithID)
+	wt := "integration-activity-heartbeat-cancellation-test-type"
+	tl := "integration-activity-heartbeat-cancellation-test-tasklist-" + strconv.FormatBool(pollWithID)
+	identity := "worker1"
+	activityName := "activity_heartbeat"
+
+	workflowType := &commonpb.WorkflowType{Name: wt}
+
+	taskList := &tasklistpb.TaskList{Name: tl}
+
+	request := &workflowservice.StartWorkflowExecutionRequest{
+		RequestId:                  uuid.New(),
+		Namespace:                  s.namespace,
+		WorkflowId:                 id,
+		WorkflowType:               workflowType,
+		TaskList:                   taskList,
+		Input:                      nil,
+		WorkflowRunTimeoutSeconds:  100,
+		WorkflowTaskTimeoutSeconds: 1,
+		Identity:                   identity,
+	}
+
+	we, err0 := s.engine.StartWorkflowExecution(NewContext(), request)
+	s.NoError(err0)
+
+	s.Logger.Info("StartWorkflowExecution: response", tag.WorkflowRunID(we.GetRunId()))
+
+	scheduleActivity := true
+	requestCancellation := false
+	activityScheduleID := int64(0)
+
+	dtHandler := func(execution *commonpb.WorkflowExecution, wt *commonpb.WorkflowType,
+		previousStartedEventID, startedEventID int64, history *historypb.History) ([]*decisionpb.Decision, error) {
+		if scheduleActivity {
+			activityScheduleID = startedEventID + 2
+			return []*decisionpb.Decision{{
+				DecisionType: enumspb.DECISION_TYPE_SCHEDULE_ACTIVITY_TASK,
+				Attributes: &decisionpb.Decision_ScheduleActivityTaskDecisionAttributes{ScheduleActivityTaskDecisionAttributes: &decisionpb.ScheduleActivityTaskDecisionAttributes{
+					ActivityId:                    "1",
+					ActivityType:                  &commonpb.ActivityType{Name: activityName},
+					TaskList:                      &tasklistpb.TaskList{Name: tl},
+					Input:                         payloads.EncodeString("activity input"),
+					ScheduleToCloseTimeoutSeconds: 15,
+					ScheduleToStartTimeoutSeconds: 10,
+					StartToCloseTimeoutSeconds:    15,
+					HeartbeatTimeoutSeconds:       0,
+				}},
+			}}, nil
+		}
+
+		if requestCancellation {
+			return []*decisionpb.Decision{{
+				DecisionType: enumspb.DECISION_TYPE_REQUEST_CANCEL_ACTIVITY_TASK,
+				Attributes: &decisionpb.Decision_RequestCancelActivityTaskDecisionAttributes{RequestCancelActivityTaskDecisionAttributes: &decisionpb.RequestCancelActivityTaskDecisionAttributes{
+					ScheduledEventId: activityScheduleID,
+				}},
+			}}, nil
+		}
+
+		s.Logger.Info("Completing Workflow")
+
+		return []*decisionpb.Decision{{
+			DecisionType: enumspb.DECISION_TYPE_COMPLETE_WORKFLOW_EXECUTION,
+			Attributes: &decisionpb.Decision_CompleteWorkflowExecutionDecisionAttributes{CompleteWorkflowExecutionDecisionAttributes: &decisionpb.CompleteWorkflowExecutionDecisionAttributes{
+				Result: payloads.EncodeString("Done"),
+			}},
+		}}, nil
+	}
+
+	cancelRequestedObserved := false
+	activityStartedCh := make(chan struct{})
+	atHandler := func(execution *commonpb.WorkflowExecution, activityType *commonpb.ActivityType,
+		activityID string, input *commonpb.Payloads, taskToken []byte, heartbeat activityTaskHeartbeater) (*commonpb.Payloads, bool, error) {
+		s.Equal(id, execution.GetWorkflowId())
+		s.Equal(activityName, activityType.GetName())
+		for i := 0; i < 500; i++ {
+			s.Logger.Info("Heartbeating for activity", tag.WorkflowActivityID(activityID), tag.Counter(i))
+			cancelRequested, err := heartbeat(payloads.EncodeString("details"))
+			s.NoError(err)
+			if i == 0 {
+				close(activityStartedCh)
+			}
+			if cancelRequested {
+				cancelRequestedObserved = true
+				return nil, true, nil
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return payloads.EncodeString("Activity Result"), false, nil
+	}
+
+	poller := &TaskPoller{
+		Engine:                       s.engine,
+		Namespace:                    s.namespace,
+		TaskList:                     taskList,
+		Identity:                     identity,
+		DecisionHandler:              dtHandler,
+		ActivityHandlerWithHeartbeat: atHandler,
+		Logger:                       s.Logger,
+		T:                            s.T(),
+	}
+
+	_, err := poller.PollAndProcessDecisionTask(false, false)
+	s.NoError(err)
+
+	cancelCh := make(chan struct{})
+	go func() {
+		// wait for the activity to be started & heartbeating before requesting the cancellation
+		<-activityStartedCh
+		s.Logger.Info("Trying to cancel the task in a different thread")
+		scheduleActivity = false
+		requestCancellation = true
+		// signal the workflow so there is a decision task to carry the cancellation request
+		_, err := s.engine.SignalWorkflowExecution(NewContext(), &workflowservice.SignalWorkflowExecutionRequest{
+			Namespace:         s.namespace,
+			WorkflowExecution: &commonpb.WorkflowExecution{WorkflowId: id, RunId: we.GetRunId()},
+			SignalName:        "request-cancellation",
+			Identity:          identity,
+			RequestId:         uuid.New(),
+		})
+		s.NoError(err)
+		_, err = poller.PollAndProcessDecisionTask(false, false)
+		s.NoError(err)
+		cancelCh <- struct{}{}
+	}()
+
+	if pollWithID {
+		err = poller.PollAndProcessActivityTaskWithID(false)
+	} else {
+		err = poller.PollAndProcessActivityTask(false)
+	}
+	s.NoError(err)
+	<-cancelCh
+	s.True(cancelRequestedObserved)
+
+	// activity canceled event will trigger a new decision
+	requestCancellation = false
+	_, err = poller.PollAndProcessDecisionTask(false, false)
+	s.NoError(err)
+
+	activityCanceled := false
+	for _, event := range s.getHistory(s.namespace, &commonpb.WorkflowExecution{WorkflowId: id, RunId: we.GetRunId()}) {
+		if event.GetEventType() == enumspb.EVENT_TYPE_ACTIVITY_TASK_CANCELED {
+			activityCanceled = true
+		}
+	}
+	s.True(activityCanceled)
+}
+
+func (s *integrationSuite) TestActivityPollCanceledByParentContext() {
+	tl := "integration-activity-poll-canceled-test-tasklist"
+	identity := "worker1"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	poller := &TaskPoller{
+		Engine:    s.engine,
+		Namespace: s.namespace,
+		TaskList:  &tasklistpb.TaskList{Name: tl},
+		Identity:  identity,
+		ActivityHandler: func(execution *commonpb.WorkflowExecution, activityType *commonpb.ActivityType,
+			activityID string, input *commonpb.Payloads, taskToken []byte) (*commonpb.Payloads, bool, error) {
+			s.Fail("no activity task should be dispatched")
+			return nil, false, nil
+		},
+		Logger:  s.Logger,
+		T:       s.T(),
+		Context: ctx,
+	}
+
+	// there is no activity task, so the poll will only return once the parent context is canceled
+	// NOTE: frontend swallows the context cancellation error of long poll, so only the latency is verified
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	err := poller.PollAndProcessActivityTask(false)
+	s.Logger.Info("PollAndProcessActivityTask returned after parent context canceled", tag.Error(err))
+	s.True(time.Now().Before(start.Add(time.Second * 5)))
+}
+
+func (s *integrationSuite) TestActivityPollAndProcessTasksConcurrently() {
+	s.testActivityPollAndProcessTasksConcurrently("integration-activity-concurrent-pollers-test", 4, 2, 8)
+}
+
+func (s *integrationSuite) TestActivityPollAndProcessTasksConcurrently_MorePollsThanTasks() {
+	s.testActivityPollAndProcessTasksConcurrently("integration-activity-concurrent-pollers-empty-test", 4, 2, 4)
+}
+
+func (s *integrationSuite) testActivityPollAndProcessTasksConcurrently(id string, workers int, iterations int, activityCount int) {
+	wt := id + "-type"
+	tl := id + "-tasklist"
+	identity := "worker1"
+	activityName := "activity_concurrent"
+
+	request := &workflowservice.StartWorkflowExecutionRequest{
+		RequestId:                  uuid.New(),
+		Namespace:                  s.namespace,
+		WorkflowId:                 id,
+		WorkflowType:               &commonpb.WorkflowType{Name: wt},
+		TaskList:                   &tasklistpb.TaskList{Name: tl},
+		Input:                      nil,
+		WorkflowRunTimeoutSeconds:  100,
+		WorkflowTaskTimeoutSeconds: 10,
+		Identity:                   identity,
+	}
+
+	we, err0 := s.engine.StartWorkflowExecution(NewContext(), request)
+	s.NoError(err0)
+
+	s.Logger.Info("StartWorkflowExecution: response", tag.WorkflowRunID(we.GetRunId()))
+
+	dtHandler := func(execution *commonpb.WorkflowExecution, wt *commonpb.WorkflowType,
+		previousStartedEventID, startedEventID int64, history *historypb.History) ([]*decisionpb.Decision, error) {
+
+		var decisions []*decisionpb.Decision
+		for i := 0; i < activityCount; i++ {
+			decisions = append(decisions, &decisionpb.Decision{
+				DecisionType: enumspb.DECISION_TYPE_SCHEDULE_ACTIVITY_TASK,
+				Attributes: &decisionpb.Decision_ScheduleActivityTaskDecisionAttributes{ScheduleActivityTaskDecisionAttributes: &decisionpb.ScheduleActivityTaskDecisionAttributes{
+					ActivityId:                    strconv.Itoa(i),
+					ActivityType:                  &commonpb.ActivityType{Name: activityName},
+					TaskList:                      &tasklistpb.TaskList{Name: tl},
+					Input:                         payloads.EncodeString("activity input"),
+					ScheduleToCloseTimeoutSeconds: 100,
+					ScheduleToStartTimeoutSeconds: 50,
+					StartToCloseTimeoutSeconds:    50,
+					HeartbeatTimeoutSeconds:       0,
+				}},
+			})
+		}
+		return decisions, nil
+	}
+
+	var lock sync.Mutex
+	processedActivityIDs := make(map[string]struct{})
+	atHandler := func(execution *commonpb.WorkflowExecution, activityType *commonpb.ActivityType,
+		activityID string, input *commonpb.Payloads, taskToken []byte) (*commonpb.Payloads, bool, error) {
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		s.Equal(activityName, activityType.GetName())
+		processedActivityIDs[activityID] = struct{}{}
+		return payloads.EncodeString("Activity Result"), false, nil
+	}
+
+	decisionPoller := &TaskPoller{
+		Engine:          s.engine,
+		Namespace:       s.namespace,
+		TaskList:        &tasklistpb.TaskList{Name: tl},
+		Identity:        identity,
+		DecisionHandler: dtHandler,
+		Logger:          s.Logger,
+		T:               s.T(),
+	}
+	_, err := decisionPoller.PollAndProcessDecisionTask(false, false)
+	s.NoError(err)
+
+	activityPoller := &TaskPoller{
+		Engine:          s.engine,
+		Namespace:       s.namespace,
+		TaskList:        &tasklistpb.TaskList{Name: tl},
+		Identity:        identity,
+		ActivityHandler: atHandler,
+		Logger:          s.Logger,
+		T:               s.T(),
+	}
+	processed, empty, err := activityPoller.PollAndProcessTasksConcurrently(workers, iterations)
+	s.NoError(err)
+	s.Equal(activityCount, processed)
+	s.Equal(workers*iterations-activityCount, empty)
+	s.Len(processedActivityIDs, activityCount)
+}
+
+func (s *integrationSuite) TestActivityDrainActivityTasks() {
+	id := "integration-activity-drain-test"
+	wt := "integration-activity-drain-test-type"
+	tl := "integration-activity-drain-test-tasklist"
+	identity := "worker1"
+	activityName := "activity_drain"
+	activityCount := 3
+
+	request := &workflowservice.StartWorkflowExecutionRequest{
+		RequestId:                  uuid.New(),
+		Namespace:                  s.namespace,
+		WorkflowId:                 id,
+		WorkflowType:               &commonpb.WorkflowType{Name: wt},
+		TaskList:                   &tasklistpb.TaskList{Name: tl},
+		Input:                      nil,
+		WorkflowRunTimeoutSeconds:  100,
+		WorkflowTaskTimeoutSeconds: 10,
+		Identity:                   identity,
+	}
+
+	we, err0 := s.engine.StartWorkflowExecution(NewContext(), request)
+	s.NoError(err0)
+
+	s.Logger.Info("StartWorkflowExecution: response", tag.WorkflowRunID(we.GetRunId()))
+
+	dtHandler := func(execution *commonpb.WorkflowExecution, wt *commonpb.WorkflowType,
+		previousStartedEventID, startedEventID int64, history *historypb.History) ([]*decisionpb.Decision, error) {
+
+		var decisions []*decisionpb.Decision
+		for i := 0; i < activityCount; i++ {
+			decisions = append(decisions, &decisionpb.Decision{
+				DecisionType: enumspb.DECISION_TYPE_SCHEDULE_ACTIVITY_TASK,
+				Attributes: &decisionpb.Decision_ScheduleActivityTaskDecisionAttributes{ScheduleActivityTaskDecisionAttributes: &decisionpb.ScheduleActivityTaskDecisionAttributes{
+					ActivityId:                    strconv.Itoa(i),
+					ActivityType:                  &commonpb.ActivityType{Name: activityName},
+					TaskList:                      &tasklistpb.TaskList{Name: tl},
+					Input:                         payloads.EncodeString("activity input"),
+					ScheduleToCloseTimeoutSeconds: 100,
+					ScheduleToStartTimeoutSeconds: 50,
+					StartToCloseTimeoutSeconds:    50,
+					HeartbeatTimeoutSeconds:       0,
+				}},
+			})
+		}
+		return decisions, nil
+	}
+
+	atHandler := func(execution *commonpb.WorkflowExecution, activityType *commonpb.ActivityType,
+		activityID string, input *commonpb.Payloads, taskToken []byte) (*commonpb.Payloads, bool, error) {
+
+		s.Fail("Drained activity task should not be handled", "activityID: %v", activityID)
+		return nil, false, nil
+	}
+
+	poller := &TaskPoller{
+		Engine:          s.engine,
+		Namespace:       s.namespace,
+		TaskList:        &tasklistpb.TaskList{Name: tl},
+		Identity:        identity,
+		DecisionHandler: dtHandler,
+		ActivityHandler: atHandler,
+		Logger:          s.Logger,
+		T:               s.T(),
+	}
+	_, err := poller.PollAndProcessDecisionTask(false, false)
+	s.NoError(err)
+
+	// asking for more tasks than scheduled returns on the first empty poll
+	dropped, err := poller.DrainActivityTasks(activityCount + 2)
+	s.NoError(err)
+	s.Equal(activityCount, dropped)
+
+	historyEvents := s.getHistory(s.namespace, &commonpb.WorkflowExecution{WorkflowId: id, RunId: we.GetRunId()})
+	for _, event := range historyEvents {
+		s.NotEqual(enumspb.EVENT_TYPE_ACTIVITY_TASK_COMPLETED, event.GetEventType())
+	}
+}
+
+func (s *integrationSuite) TestActivityDrainDecisionTasks() {
+	id := "integration-activity-drain-decision-test"
+	wt := "integration-activity-drain-decision-test-type"
+	tl := "integration-activity-drain-decision-test-tasklist"
+	identity := "worker1"
+	workflowCount := 3
+
+	var runIDs []string
+	for i := 0; i < workflowCount; i++ {
+		request := &workflowservice.StartWorkflowExecutionRequest{
+			RequestId:                  uuid.New(),
+			Namespace:                  s.namespace,
+			WorkflowId:                 fmt.Sprintf("%v-%v", id, i),
+			WorkflowType:               &commonpb.WorkflowType{Name: wt},
+			TaskList:                   &tasklistpb.TaskList{Name: tl},
+			Input:                      nil,
+			WorkflowRunTimeoutSeconds:  100,
+			WorkflowTaskTimeoutSeconds: 10,
+			Identity:                   identity,
+		}
+
+		we, err0 := s.engine.StartWorkflowExecution(NewContext(), request)
+		s.NoError(err0)
+		s.Logger.Info("StartWorkflowExecution: response", tag.WorkflowRunID(we.GetRunId()))
+		runIDs = append(runIDs, we.GetRunId())
+	}
+
+	dtHandler := func(execution *commonpb.WorkflowExecution, wt *commonpb.WorkflowType,
+		previousStartedEventID, startedEventID int64, history *historypb.History) ([]*decisionpb.Decision, error) {
+
+		s.Fail("Drained decision task should not be handled", "workflowID: %v", execution.GetWorkflowId())
+		return nil, nil
+	}
+
+	poller := &TaskPoller{
+		Engine:          s.engine,
+		Namespace:       s.namespace,
+		TaskList:        &tasklistpb.TaskList{Name: tl},
+		Identity:        identity,
+		DecisionHandler: dtHandler,
+		Logger:          s.Logger,
+		T:               s.T(),
+	}
+
+	// asking for more tasks than scheduled returns on the first empty poll
+	dropped, err := poller.DrainDecisionTasks(workflowCount + 2)
+	s.NoError(err)
+	s.Equal(workflowCount, dropped)
+
+	for i, runID := range runIDs {
+		historyEvents := s.getHistory(s.namespace, &commonpb.WorkflowExecution{WorkflowId: fmt.Sprintf("%v-%v", id, i), RunId: runID})
+		for _, event := range historyEvents {
+			s.NotEqual(enumspb.EVENT_TYPE_DECISION_TASK_COMPLETED, event.GetEventType())
+		}
+	}
+}
+
+func (s *integrationSuite) TestActivityFailedWithSDKError() {
+	id := "integration-activity-failed-sdk-error-test"
+	wt := "integration-activity-failed-sdk-error-test-type"
+	tl := "integration-activity-failed-sdk-error-test-tasklist"
+	identity := "worker1"
+	activityName := "activity_failed_sdk_error"
+
+	request := &workflowservice.StartWorkflowExecutionRequest{
+		RequestId:                  uuid.New(),
+		Namespace:                  s.namespace,
+		WorkflowId:                 id,
+		WorkflowType:               &commonpb.WorkflowType{Name: wt},
+		TaskList:                   &tasklistpb.TaskList{Name: tl},
+		Input:                      nil,
+		WorkflowRunTimeoutSeconds:  100,
+		WorkflowTaskTimeoutSeconds: 10,
+		Identity:                   identity,
+	}
+
+	we, err0 := s.engine.StartWorkflowExecution(NewContext(), request)
+	s.NoError(err0)
+
+	s.Logger.Info("StartWorkflowExecution: response", tag.WorkflowRunID(we.GetRunId()))
+
+	activityErrors := map[string]error{
+		"canceled":    temporal.NewCanceledError(),
+		"timeout":     temporal.NewTimeoutError(enumspb.TIMEOUT_TYPE_START_TO_CLOSE, nil),
+		"terminated":  &temporal.TerminatedError{},
+		"panic":       &temporal.PanicError{},
+		"application": temporal.NewNonRetryableApplicationError("application error", nil),
+	}
+	applicationDetails := "application error details"
+
+	dtHandler := func(execution *commonpb.WorkflowExecution, wt *commonpb.WorkflowType,
+		previousStartedEventID, startedEventID int64, history *historypb.History) ([]*decisionpb.Decision, error) {
+
+		var decisions []*decisionpb.Decision
+		for activityID := range activityErrors {
+			decisions = append(decisions, &decisionpb.Decision{
+				DecisionType: enumspb.DECISION_TYPE_SCHEDULE_ACTIVITY_TASK,
+				Attributes: &decisionpb.Decision_ScheduleActivityTaskDecisionAttributes{ScheduleActivityTaskDecisionAttributes: &decisionpb.ScheduleActivityTaskDecisionAttributes{
+					ActivityId:                    activityID,
+					ActivityType:                  &commonpb.ActivityType{Name: activityName},
+					TaskList:                      &tasklistpb.TaskList{Name: tl},
+					Input:                         payloads.EncodeString("activity input"),
+					ScheduleToCloseTimeoutSeconds: 100,
+					ScheduleToStartTimeoutSeconds: 50,
+					StartToCloseTimeoutSeconds:    50,
+					HeartbeatTimeoutSeconds:       0,
+				}},
+			})
+		}
+		return decisions, nil
+	}
+
+	atHandler := func(execution *commonpb.WorkflowExecution, activityType *commonpb.ActivityType,
+		activityID string, input *commonpb.Payloads, taskToken []byte) (*commonpb.Payloads, bool, error) {
+
+		if activityID == "application" {
+			return payloads.EncodeString(applicationDetails), false, activityErrors[activityID]
+		}
+		return nil, false, activityErrors[activityID]
+	}
+
+	poller := &TaskPoller{
+		Engine:          s.engine,
+		Namespace:       s.namespace,
+		TaskList:        &tasklistpb.TaskList{Name: tl},
+		Identity:        identity,
+		DecisionHandler: dtHandler,
+		ActivityHandler: atHandler,
+		Logger:          s.Logger,
+		T:               s.T(),
+	}
+	_, err := poller.PollAndProcessDecisionTask(false, false)
+	s.NoError(err)
+
+	for range activityErrors {
+		err = poller.PollAndProcessActivityTask(false)
+		s.NoError(err)
+	}
+
+	historyEvents := s.getHistory(s.namespace, &commonpb.WorkflowExecution{WorkflowId: id, RunId: we.GetRunId()})
+	activityIDs := make(map[int64]string)
+	failedCount := 0
+	for _, event := range historyEvents {
+		switch event.GetEventType() {
+		case enumspb.EVENT_TYPE_ACTIVITY_TASK_SCHEDULED:
+			activityIDs[event.GetEventId()] = event.GetActivityTaskScheduledEventAttributes().GetActivityId()
+		case enumspb.EVENT_TYPE_ACTIVITY_TASK_FAILED:
+			failedCount++
+			attributes := event.GetActivityTaskFailedEventAttributes()
+			switch activityIDs[attributes.GetScheduledEventId()] {
+			case "canceled":
+				s.NotNil(attributes.GetFailure().GetCanceledFailureInfo())
+			case "timeout":
+				s.NotNil(attributes.GetFailure().GetTimeoutFailureInfo())
+				s.Equal(enumspb.TIMEOUT_TYPE_START_TO_CLOSE, attributes.GetFailure().GetTimeoutFailureInfo().GetTimeoutType())
+			case "terminated":
+				s.NotNil(attributes.GetFailure().GetTerminatedFailureInfo())
+			case "panic":
+				s.Equal("PanicError", attributes.GetFailure().GetApplicationFailureInfo().GetType())
+			case "application":
+				applicationFailureInfo := attributes.GetFailure().GetApplicationFailureInfo()
+				s.NotNil(applicationFailureInfo)
+				s.True(applicationFailureInfo.GetNonRetryable())
+				var details string
+				err := payloads.Decode(applicationFailureInfo.GetDetails(), &details)
+				s.NoError(err)
+				s.Equal(applicationDetails, details)
+			default:
+				s.Fail("Unexpected activity failed", "scheduled event ID: %v", attributes.GetScheduledEventId())
+			}
+		}
+	}
+	s.Equal(len(activityErrors), failedCount)
+}
@@ -31,8 +31,10 @@ import (
 	"github.com/temporalio/temporal/common/rpc"
 )
 
+const defaultContextTimeout = 90 * time.Second
+
 // NewContext create new context with default timeout 90 seconds.
 func NewContext() context.Context {
-	ctx, _ := rpc.NewContextWithTimeoutAndHeaders(90 * time.Second)
+	ctx, _ := rpc.NewContextWithTimeoutAndHeaders(defaultContextTimeout)
 	return ctx
 }
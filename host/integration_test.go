@@ -3179,6 +3179,173 @@ WaitForStickyTimeoutLoop:
 	s.Equal(2, failedDecisions, "Mismatched failed decision count")
 }
 
+func (s *integrationSuite) TestStickyDecisionTaskCompleted_BinaryChecksum() {
+	id := "integration-sticky-decision-binary-checksum"
+	wt := "integration-sticky-decision-binary-checksum-type"
+	tl := "integration-sticky-decision-binary-checksum-tasklist"
+	stl := "integration-sticky-decision-binary-checksum-tasklist-sticky"
+	identity := "worker1"
+	binaryChecksum1 := "binary-checksum-1"
+	binaryChecksum2 := "binary-checksum-2"
+
+	// Start workflow execution
+	request := &workflowservice.StartWorkflowExecutionRequest{
+		RequestId:                  uuid.New(),
+		Namespace:                  s.namespace,
+		WorkflowId:                 id,
+		WorkflowType:               &commonpb.WorkflowType{Name: wt},
+		TaskList:                   &tasklistpb.TaskList{Name: tl},
+		Input:                      nil,
+		WorkflowRunTimeoutSeconds:  100,
+		WorkflowTaskTimeoutSeconds: 10,
+		Identity:                   identity,
+	}
+
+	we, err0 := s.engine.StartWorkflowExecution(NewContext(), request)
+	s.NoError(err0)
+
+	s.Logger.Info("StartWorkflowExecution", tag.WorkflowRunID(we.RunId))
+	workflowExecution := &commonpb.WorkflowExecution{
+		WorkflowId: id,
+		RunId:      we.RunId,
+	}
+
+	// decider logic
+	decisionCount := 0
+	var stickyPreviousStartedEventID int64
+	var stickyHistoryEvents []*historypb.HistoryEvent
+	dtHandler := func(execution *commonpb.WorkflowExecution, wt *commonpb.WorkflowType,
+		previousStartedEventID, startedEventID int64, history *historypb.History) ([]*decisionpb.Decision, error) {
+
+		decisionCount++
+		if decisionCount == 1 {
+			return nil, nil
+		}
+
+		stickyPreviousStartedEventID = previousStartedEventID
+		stickyHistoryEvents = history.GetEvents()
+
+		return []*decisionpb.Decision{{
+			DecisionType: enumspb.DECISION_TYPE_COMPLETE_WORKFLOW_EXECUTION,
+			Attributes: &decisionpb.Decision_CompleteWorkflowExecutionDecisionAttributes{CompleteWorkflowExecutionDecisionAttributes: &decisionpb.CompleteWorkflowExecutionDecisionAttributes{
+				Result: payloads.EncodeString("Done"),
+			}},
+		}}, nil
+	}
+
+	poller := &TaskPoller{
+		Engine:                              s.engine,
+		Namespace:                           s.namespace,
+		TaskList:                            &tasklistpb.TaskList{Name: tl},
+		Identity:                            identity,
+		BinaryChecksum:                      binaryChecksum1,
+		DecisionHandler:                     dtHandler,
+		Logger:                              s.Logger,
+		T:                                   s.T(),
+		StickyTaskList:                      &tasklistpb.TaskList{Name: stl},
+		StickyScheduleToStartTimeoutSeconds: 10,
+	}
+
+	_, err := poller.PollAndProcessDecisionTaskWithAttempt(false, false, false, true, int64(0))
+	s.Logger.Info("PollAndProcessDecisionTask", tag.Error(err))
+	s.NoError(err)
+
+	_, err = s.engine.SignalWorkflowExecution(NewContext(), &workflowservice.SignalWorkflowExecutionRequest{
+		Namespace:         s.namespace,
+		WorkflowExecution: workflowExecution,
+		SignalName:        "signalA",
+		Input:             payloads.EncodeString("signal input"),
+		Identity:          identity,
+		RequestId:         uuid.New(),
+	})
+	s.NoError(err)
+
+	// NOTE: a different binary checksum does not evict the sticky tasklist by itself,
+	// only a checksum marked as bad binary in the namespace config fails the decision
+	poller.BinaryChecksum = binaryChecksum2
+	_, err = poller.PollAndProcessDecisionTaskWithAttempt(false, false, true, true, int64(0))
+	s.Logger.Info("PollAndProcessDecisionTask", tag.Error(err))
+	s.NoError(err)
+	s.Equal(2, decisionCount)
+
+	// sticky decision task still carries the partial history, i.e. events after the previous decision started
+	s.True(stickyPreviousStartedEventID > 0)
+	s.True(len(stickyHistoryEvents) > 1)
+	s.Equal(stickyPreviousStartedEventID+1, stickyHistoryEvents[0].GetEventId())
+	s.Equal(enumspb.EVENT_TYPE_DECISION_TASK_COMPLETED, stickyHistoryEvents[0].GetEventType())
+	s.Equal(enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_SIGNALED, stickyHistoryEvents[1].GetEventType())
+
+	var binaryChecksums []string
+	events := s.getHistory(s.namespace, workflowExecution)
+	for _, event := range events {
+		if event.GetEventType() == enumspb.EVENT_TYPE_DECISION_TASK_COMPLETED {
+			binaryChecksums = append(binaryChecksums, event.GetDecisionTaskCompletedEventAttributes().GetBinaryChecksum())
+		}
+	}
+	s.Equal([]string{binaryChecksum1, binaryChecksum2}, binaryChecksums)
+}
+
+func (s *integrationSuite) TestDecisionTaskDumpHistoryToSink() {
+	id := "integration-decision-dump-history-sink"
+	wt := "integration-decision-dump-history-sink-type"
+	tl := "integration-decision-dump-history-sink-tasklist"
+	identity := "worker1"
+
+	// Start workflow execution
+	request := &workflowservice.StartWorkflowExecutionRequest{
+		RequestId:                  uuid.New(),
+		Namespace:                  s.namespace,
+		WorkflowId:                 id,
+		WorkflowType:               &commonpb.WorkflowType{Name: wt},
+		TaskList:                   &tasklistpb.TaskList{Name: tl},
+		Input:                      nil,
+		WorkflowRunTimeoutSeconds:  100,
+		WorkflowTaskTimeoutSeconds: 10,
+		Identity:                   identity,
+	}
+
+	we, err0 := s.engine.StartWorkflowExecution(NewContext(), request)
+	s.NoError(err0)
+
+	s.Logger.Info("StartWorkflowExecution", tag.WorkflowRunID(we.RunId))
+
+	// decider logic
+	dtHandler := func(execution *commonpb.WorkflowExecution, wt *commonpb.WorkflowType,
+		previousStartedEventID, startedEventID int64, history *historypb.History) ([]*decisionpb.Decision, error) {
+
+		return []*decisionpb.Decision{{
+			DecisionType: enumspb.DECISION_TYPE_COMPLETE_WORKFLOW_EXECUTION,
+			Attributes: &decisionpb.Decision_CompleteWorkflowExecutionDecisionAttributes{CompleteWorkflowExecutionDecisionAttributes: &decisionpb.CompleteWorkflowExecutionDecisionAttributes{
+				Result: payloads.EncodeString("Done"),
+			}},
+		}}, nil
+	}
+
+	var dumpedHistories []*historypb.History
+	poller := &TaskPoller{
+		Engine:          s.engine,
+		Namespace:       s.namespace,
+		TaskList:        &tasklistpb.TaskList{Name: tl},
+		Identity:        identity,
+		DecisionHandler: dtHandler,
+		HistorySink: func(history *historypb.History) {
+			dumpedHistories = append(dumpedHistories, history)
+		},
+		Logger: s.Logger,
+		T:      s.T(),
+	}
+
+	_, err := poller.PollAndProcessDecisionTask(true, false)
+	s.Logger.Info("PollAndProcessDecisionTask", tag.Error(err))
+	s.NoError(err)
+
+	// workflow started, decision task scheduled, decision task started
+	s.Len(dumpedHistories, 1)
+	s.Len(dumpedHistories[0].GetEvents(), 3)
+	s.Equal(enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_STARTED, dumpedHistories[0].GetEvents()[0].GetEventType())
+	s.Equal(enumspb.EVENT_TYPE_DECISION_TASK_STARTED, dumpedHistories[0].GetEvents()[2].GetEventType())
+}
+
 func (s *integrationSuite) TestBufferedEventsOutOfOrder() {
 	id := "integration-buffered-events-out-of-order-test"
 	wt := "integration-buffered-events-out-of-order-test-type"
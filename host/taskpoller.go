@@ -25,9 +25,12 @@
 package host
 
 import (
+	"context"
 	"errors"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.temporal.io/temporal"
@@ -39,23 +42,33 @@ import (
 	querypb "go.temporal.io/temporal-proto/query/v1"
 	tasklistpb "go.temporal.io/temporal-proto/tasklist/v1"
 	"go.temporal.io/temporal-proto/workflowservice/v1"
+	"go.uber.org/multierr"
 
 	"github.com/temporalio/temporal/common"
 	"github.com/temporalio/temporal/common/log"
 	"github.com/temporalio/temporal/common/log/tag"
 	"github.com/temporalio/temporal/common/payloads"
+	"github.com/temporalio/temporal/common/rpc"
 	"github.com/temporalio/temporal/service/history"
 	"github.com/temporalio/temporal/service/matching"
 )
 
 type (
+	// NOTE: handlers must be goroutine-safe if the poller is used by PollAndProcessTasksConcurrently
 	decisionTaskHandler func(execution *commonpb.WorkflowExecution, wt *commonpb.WorkflowType,
 		previousStartedEventID, startedEventID int64, history *historypb.History) ([]*decisionpb.Decision, error)
 	activityTaskHandler func(execution *commonpb.WorkflowExecution, activityType *commonpb.ActivityType,
 		activityID string, input *commonpb.Payloads, takeToken []byte) (*commonpb.Payloads, bool, error)
 
+	activityTaskHeartbeater          func(details *commonpb.Payloads) (cancelRequested bool, err error)
+	activityTaskHandlerWithHeartbeat func(execution *commonpb.WorkflowExecution, activityType *commonpb.ActivityType,
+		activityID string, input *commonpb.Payloads, takeToken []byte, heartbeat activityTaskHeartbeater) (*commonpb.Payloads, bool, error)
+
 	queryHandler func(task *workflowservice.PollForDecisionTaskResponse) (*commonpb.Payloads, error)
 
+	// historySink, if set as TaskPoller.HistorySink, receives the polled history instead of the logger when dumping history
+	historySink func(history *historypb.History)
+
 	// TaskPoller is used in integration tests to poll decision or activity tasks
 	TaskPoller struct {
 		Engine                              FrontendClient
@@ -64,11 +77,16 @@ type (
 		StickyTaskList                      *tasklistpb.TaskList
 		StickyScheduleToStartTimeoutSeconds int32
 		Identity                            string
+		BinaryChecksum                      string
 		DecisionHandler                     decisionTaskHandler
 		ActivityHandler                     activityTaskHandler
+		ActivityHandlerWithHeartbeat        activityTaskHandlerWithHeartbeat
 		QueryHandler                        queryHandler
+		HistorySink                         historySink
 		Logger                              log.Logger
 		T                                   *testing.T
+		ContextTimeout                      time.Duration
+		Context                             context.Context
 	}
 )
 
@@ -82,6 +100,42 @@ func (p *TaskPoller) PollAndProcessDecisionTaskWithSticky(dumpHistory bool, drop
 	return p.PollAndProcessDecisionTaskWithAttempt(dumpHistory, dropTask, true, true, int64(0))
 }
 
+// PollAndProcessDecisionTaskReturningResponse for decision tasks, returns the response of decision task completion
+func (p *TaskPoller) PollAndProcessDecisionTaskReturningResponse(
+	dumpHistory bool,
+	dropTask bool,
+	forceCreateNewDecision bool,
+) (isQueryTask bool, newTask *workflowservice.RespondDecisionTaskCompletedResponse, err error) {
+
+	return p.PollAndProcessDecisionTaskWithAttemptAndRetryAndForceNewDecision(
+		dumpHistory,
+		dropTask,
+		false,
+		false,
+		int64(0),
+		5,
+		forceCreateNewDecision,
+		nil)
+}
+
+// PollAndProcessDecisionTaskWithStickyReturningResponse for decision tasks, returns the response of decision task completion
+func (p *TaskPoller) PollAndProcessDecisionTaskWithStickyReturningResponse(
+	dumpHistory bool,
+	dropTask bool,
+	forceCreateNewDecision bool,
+) (isQueryTask bool, newTask *workflowservice.RespondDecisionTaskCompletedResponse, err error) {
+
+	return p.PollAndProcessDecisionTaskWithAttemptAndRetryAndForceNewDecision(
+		dumpHistory,
+		dropTask,
+		true,
+		true,
+		int64(0),
+		5,
+		forceCreateNewDecision,
+		nil)
+}
+
 // PollAndProcessDecisionTaskWithoutRetry for decision tasks
 func (p *TaskPoller) PollAndProcessDecisionTaskWithoutRetry(dumpHistory bool, dropTask bool) (isQueryTask bool, err error) {
 	return p.PollAndProcessDecisionTaskWithAttemptAndRetry(dumpHistory, dropTask, false, false, int64(0), 1)
@@ -145,7 +199,7 @@ Loop:
 		if pollStickyTaskList {
 			taskList = p.StickyTaskList
 		}
-		response, err1 := p.Engine.PollForDecisionTask(NewContext(), &workflowservice.PollForDecisionTaskRequest{
+		response, err1 := p.Engine.PollForDecisionTask(p.newContext(), &workflowservice.PollForDecisionTaskRequest{
 			Namespace: p.Namespace,
 			TaskList:  taskList,
 			Identity:  p.Identity,
@@ -181,7 +235,7 @@ Loop:
 
 			nextPageToken := response.NextPageToken
 			for nextPageToken != nil {
-				resp, err2 := p.Engine.GetWorkflowExecutionHistory(NewContext(), &workflowservice.GetWorkflowExecutionHistoryRequest{
+				resp, err2 := p.Engine.GetWorkflowExecutionHistory(p.newContext(), &workflowservice.GetWorkflowExecutionHistoryRequest{
 					Namespace:     p.Namespace,
 					Execution:     response.WorkflowExecution,
 					NextPageToken: nextPageToken,
@@ -211,7 +265,11 @@ Loop:
 		}
 
 		if dumpHistory {
-			common.PrettyPrintHistory(response.History, p.Logger)
+			if p.HistorySink != nil {
+				p.HistorySink(response.History)
+			} else {
+				common.PrettyPrintHistory(response.History, p.Logger)
+			}
 		}
 
 		// handle query task response
@@ -229,7 +287,7 @@ Loop:
 				completeRequest.QueryResult = blob
 			}
 
-			_, err = p.Engine.RespondQueryTaskCompleted(NewContext(), completeRequest)
+			_, err = p.Engine.RespondQueryTaskCompleted(p.newContext(), completeRequest)
 			return true, nil, err
 		}
 
@@ -247,10 +305,10 @@ Loop:
 		decisions, err := p.DecisionHandler(response.WorkflowExecution, response.WorkflowType, response.PreviousStartedEventId, response.StartedEventId, response.History)
 		if err != nil {
 			p.Logger.Error("Failing Decision. Decision handler failed with error", tag.Error(err))
-			_, err = p.Engine.RespondDecisionTaskFailed(NewContext(), &workflowservice.RespondDecisionTaskFailedRequest{
+			_, err = p.Engine.RespondDecisionTaskFailed(p.newContext(), &workflowservice.RespondDecisionTaskFailedRequest{
 				TaskToken: response.TaskToken,
 				Cause:     enumspb.DECISION_TASK_FAILED_CAUSE_WORKFLOW_WORKER_UNHANDLED_FAILURE,
-				Failure:   newApplicationFailure(err, false, nil),
+				Failure:   newFailure(err, false, nil),
 				Identity:  p.Identity,
 			})
 			return isQueryTask, nil, err
@@ -259,9 +317,10 @@ Loop:
 		p.Logger.Info("Completing Decision.  Decisions", tag.Value(decisions))
 		if !respondStickyTaskList {
 			// non sticky tasklist
-			newTask, err := p.Engine.RespondDecisionTaskCompleted(NewContext(), &workflowservice.RespondDecisionTaskCompletedRequest{
+			newTask, err := p.Engine.RespondDecisionTaskCompleted(p.newContext(), &workflowservice.RespondDecisionTaskCompletedRequest{
 				TaskToken:                  response.TaskToken,
 				Identity:                   p.Identity,
+				BinaryChecksum:             p.BinaryChecksum,
 				Decisions:                  decisions,
 				ReturnNewDecisionTask:      forceCreateNewDecision,
 				ForceCreateNewDecisionTask: forceCreateNewDecision,
@@ -271,11 +330,12 @@ Loop:
 		}
 		// sticky tasklist
 		newTask, err := p.Engine.RespondDecisionTaskCompleted(
-			NewContext(),
+			p.newContext(),
 			&workflowservice.RespondDecisionTaskCompletedRequest{
-				TaskToken: response.TaskToken,
-				Identity:  p.Identity,
-				Decisions: decisions,
+				TaskToken:      response.TaskToken,
+				Identity:       p.Identity,
+				BinaryChecksum: p.BinaryChecksum,
+				Decisions:      decisions,
 				StickyAttributes: &tasklistpb.StickyExecutionAttributes{
 					WorkerTaskList:                p.StickyTaskList,
 					ScheduleToStartTimeoutSeconds: p.StickyScheduleToStartTimeoutSeconds,
@@ -315,10 +375,10 @@ func (p *TaskPoller) HandlePartialDecision(response *workflowservice.PollForDeci
 		response.PreviousStartedEventId, response.StartedEventId, response.History)
 	if err != nil {
 		p.Logger.Error("Failing Decision. Decision handler failed with error", tag.Error(err))
-		_, err = p.Engine.RespondDecisionTaskFailed(NewContext(), &workflowservice.RespondDecisionTaskFailedRequest{
+		_, err = p.Engine.RespondDecisionTaskFailed(p.newContext(), &workflowservice.RespondDecisionTaskFailedRequest{
 			TaskToken: response.TaskToken,
 			Cause:     enumspb.DECISION_TASK_FAILED_CAUSE_WORKFLOW_WORKER_UNHANDLED_FAILURE,
-			Failure:   newApplicationFailure(err, false, nil),
+			Failure:   newFailure(err, false, nil),
 			Identity:  p.Identity,
 		})
 		return nil, err
@@ -328,11 +388,12 @@ func (p *TaskPoller) HandlePartialDecision(response *workflowservice.PollForDeci
 
 	// sticky tasklist
 	newTask, err := p.Engine.RespondDecisionTaskCompleted(
-		NewContext(),
+		p.newContext(),
 		&workflowservice.RespondDecisionTaskCompletedRequest{
-			TaskToken: response.TaskToken,
-			Identity:  p.Identity,
-			Decisions: decisions,
+			TaskToken:      response.TaskToken,
+			Identity:       p.Identity,
+			BinaryChecksum: p.BinaryChecksum,
+			Decisions:      decisions,
 			StickyAttributes: &tasklistpb.StickyExecutionAttributes{
 				WorkerTaskList:                p.StickyTaskList,
 				ScheduleToStartTimeoutSeconds: p.StickyScheduleToStartTimeoutSeconds,
@@ -347,9 +408,15 @@ func (p *TaskPoller) HandlePartialDecision(response *workflowservice.PollForDeci
 
 // PollAndProcessActivityTask for activity tasks
 func (p *TaskPoller) PollAndProcessActivityTask(dropTask bool) error {
+	_, err := p.pollAndProcessActivityTask(dropTask)
+	return err
+}
+
+// pollAndProcessActivityTask returns whether an activity task is received and handled (or dropped)
+func (p *TaskPoller) pollAndProcessActivityTask(dropTask bool) (bool, error) {
 retry:
 	for attempt := 0; attempt < 5; attempt++ {
-		response, err := p.Engine.PollForActivityTask(NewContext(), &workflowservice.PollForActivityTaskRequest{
+		response, err := p.Engine.PollForActivityTask(p.newContext(), &workflowservice.PollForActivityTaskRequest{
 			Namespace: p.Namespace,
 			TaskList:  p.TaskList,
 			Identity:  p.Identity,
@@ -361,57 +428,67 @@ retry:
 		}
 
 		if err != nil {
-			return err
+			return false, err
 		}
 
 		if response == nil || len(response.TaskToken) == 0 {
 			p.Logger.Info("Empty Activity task: Polling again")
-			return nil
+			return false, nil
 		}
 
 		if dropTask {
 			p.Logger.Info("Dropping Activity task: ")
-			return nil
+			return true, nil
 		}
 		p.Logger.Debug("Received Activity task", tag.Value(response))
 
-		result, cancel, err2 := p.ActivityHandler(response.WorkflowExecution, response.ActivityType, response.ActivityId,
-			response.Input, response.TaskToken)
+		result, cancel, err2 := p.handleActivityTask(response, func(details *commonpb.Payloads) (bool, error) {
+			resp, err := p.Engine.RecordActivityTaskHeartbeat(p.newContext(), &workflowservice.RecordActivityTaskHeartbeatRequest{
+				TaskToken: response.TaskToken,
+				Details:   details,
+				Identity:  p.Identity,
+			})
+			if err != nil {
+				return false, err
+			}
+			return resp.GetCancelRequested(), nil
+		})
 		if cancel {
 			p.Logger.Info("Executing RespondActivityTaskCanceled")
-			_, err := p.Engine.RespondActivityTaskCanceled(NewContext(), &workflowservice.RespondActivityTaskCanceledRequest{
+			_, err := p.Engine.RespondActivityTaskCanceled(p.newContext(), &workflowservice.RespondActivityTaskCanceledRequest{
 				TaskToken: response.TaskToken,
 				Details:   payloads.EncodeString("details"),
 				Identity:  p.Identity,
 			})
-			return err
+			return true, err
 		}
 
+		// result returned along with the error is used as the application failure details
 		if err2 != nil {
-			_, err := p.Engine.RespondActivityTaskFailed(NewContext(), &workflowservice.RespondActivityTaskFailedRequest{
+			_, err := p.Engine.RespondActivityTaskFailed(p.newContext(), &workflowservice.RespondActivityTaskFailedRequest{
 				TaskToken: response.TaskToken,
-				Failure:   newApplicationFailure(err2, false, nil),
+				Failure:   newFailure(err2, false, result),
 				Identity:  p.Identity,
 			})
-			return err
+			return true, err
 		}
 
-		_, err = p.Engine.RespondActivityTaskCompleted(NewContext(), &workflowservice.RespondActivityTaskCompletedRequest{
+		_, err = p.Engine.RespondActivityTaskCompleted(p.newContext(), &workflowservice.RespondActivityTaskCompletedRequest{
 			TaskToken: response.TaskToken,
 			Identity:  p.Identity,
 			Result:    result,
 		})
-		return err
+		return true, err
 	}
 
-	return matching.ErrNoTasks
+	return false, matching.ErrNoTasks
 }
 
 // PollAndProcessActivityTaskWithID is similar to PollAndProcessActivityTask but using RespondActivityTask...ByID
 func (p *TaskPoller) PollAndProcessActivityTaskWithID(dropTask bool) error {
 retry:
 	for attempt := 0; attempt < 5; attempt++ {
-		response, err1 := p.Engine.PollForActivityTask(NewContext(), &workflowservice.PollForActivityTaskRequest{
+		response, err1 := p.Engine.PollForActivityTask(p.newContext(), &workflowservice.PollForActivityTaskRequest{
 			Namespace: p.Namespace,
 			TaskList:  p.TaskList,
 			Identity:  p.Identity,
@@ -442,11 +519,23 @@ retry:
 		}
 		p.Logger.Debug("Received Activity task", tag.Value(response))
 
-		result, cancel, err2 := p.ActivityHandler(response.WorkflowExecution, response.ActivityType, response.ActivityId,
-			response.Input, response.TaskToken)
+		result, cancel, err2 := p.handleActivityTask(response, func(details *commonpb.Payloads) (bool, error) {
+			resp, err := p.Engine.RecordActivityTaskHeartbeatById(p.newContext(), &workflowservice.RecordActivityTaskHeartbeatByIdRequest{
+				Namespace:  p.Namespace,
+				WorkflowId: response.WorkflowExecution.GetWorkflowId(),
+				RunId:      response.WorkflowExecution.GetRunId(),
+				ActivityId: response.GetActivityId(),
+				Details:    details,
+				Identity:   p.Identity,
+			})
+			if err != nil {
+				return false, err
+			}
+			return resp.GetCancelRequested(), nil
+		})
 		if cancel {
 			p.Logger.Info("Executing RespondActivityTaskCanceled")
-			_, err := p.Engine.RespondActivityTaskCanceledById(NewContext(), &workflowservice.RespondActivityTaskCanceledByIdRequest{
+			_, err := p.Engine.RespondActivityTaskCanceledById(p.newContext(), &workflowservice.RespondActivityTaskCanceledByIdRequest{
 				Namespace:  p.Namespace,
 				WorkflowId: response.WorkflowExecution.GetWorkflowId(),
 				RunId:      response.WorkflowExecution.GetRunId(),
@@ -457,19 +546,20 @@ retry:
 			return err
 		}
 
+		// result returned along with the error is used as the application failure details
 		if err2 != nil {
-			_, err := p.Engine.RespondActivityTaskFailedById(NewContext(), &workflowservice.RespondActivityTaskFailedByIdRequest{
+			_, err := p.Engine.RespondActivityTaskFailedById(p.newContext(), &workflowservice.RespondActivityTaskFailedByIdRequest{
 				Namespace:  p.Namespace,
 				WorkflowId: response.WorkflowExecution.GetWorkflowId(),
 				RunId:      response.WorkflowExecution.GetRunId(),
 				ActivityId: response.GetActivityId(),
-				Failure:    newApplicationFailure(err2, false, nil),
+				Failure:    newFailure(err2, false, result),
 				Identity:   p.Identity,
 			})
 			return err
 		}
 
-		_, err := p.Engine.RespondActivityTaskCompletedById(NewContext(), &workflowservice.RespondActivityTaskCompletedByIdRequest{
+		_, err := p.Engine.RespondActivityTaskCompletedById(p.newContext(), &workflowservice.RespondActivityTaskCompletedByIdRequest{
 			Namespace:  p.Namespace,
 			WorkflowId: response.WorkflowExecution.GetWorkflowId(),
 			RunId:      response.WorkflowExecution.GetRunId(),
@@ -483,6 +573,136 @@ retry:
 	return matching.ErrNoTasks
 }
 
+// handleActivityTask prefers ActivityHandlerWithHeartbeat over ActivityHandler if both are set
+func (p *TaskPoller) handleActivityTask(
+	response *workflowservice.PollForActivityTaskResponse,
+	heartbeat activityTaskHeartbeater,
+) (*commonpb.Payloads, bool, error) {
+
+	if p.ActivityHandlerWithHeartbeat != nil {
+		return p.ActivityHandlerWithHeartbeat(response.WorkflowExecution, response.ActivityType, response.ActivityId,
+			response.Input, response.TaskToken, heartbeat)
+	}
+	return p.ActivityHandler(response.WorkflowExecution, response.ActivityType, response.ActivityId,
+		response.Input, response.TaskToken)
+}
+
+// newContext creates RPC context using Context as parent and ContextTimeout as timeout, if set
+func (p *TaskPoller) newContext() context.Context {
+	parentCtx := p.Context
+	if parentCtx == nil {
+		parentCtx = context.Background()
+	}
+	timeout := p.ContextTimeout
+	if timeout == 0 {
+		timeout = defaultContextTimeout
+	}
+	ctx, _ := rpc.NewContextFromParentWithTimeoutAndHeaders(parentCtx, timeout)
+	return ctx
+}
+
+// PollAndProcessTasksConcurrently starts workers goroutines, each of which polls and processes
+// a decision task (if DecisionHandler is set) and an activity task (if an activity handler is set)
+// per iteration. Polls which found no task are counted as empty, other errors are aggregated.
+// NOTE: handlers are shared by all workers and must be goroutine-safe
+func (p *TaskPoller) PollAndProcessTasksConcurrently(
+	workers int,
+	iterations int,
+) (processed int, empty int, err error) {
+
+	var lock sync.Mutex
+	recordResult := func(handled bool, taskErr error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch {
+		case taskErr == nil && handled:
+			processed++
+		case taskErr == nil, taskErr == matching.ErrNoTasks:
+			empty++
+		default:
+			err = multierr.Append(err, taskErr)
+		}
+	}
+
+	var waitGroup sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for j := 0; j < iterations; j++ {
+				if p.DecisionHandler != nil {
+					// empty decision poll is retried and returned as matching.ErrNoTasks
+					_, taskErr := p.PollAndProcessDecisionTask(false, false)
+					recordResult(true, taskErr)
+				}
+				if p.ActivityHandler != nil || p.ActivityHandlerWithHeartbeat != nil {
+					recordResult(p.pollAndProcessActivityTask(false))
+				}
+			}
+		}()
+	}
+	waitGroup.Wait()
+
+	return processed, empty, err
+}
+
+// DrainDecisionTasks polls and drops up to count decision tasks, stops on first empty poll
+func (p *TaskPoller) DrainDecisionTasks(count int) (dropped int, err error) {
+	for dropped < count {
+		isDropped, err := p.pollAndDropDecisionTask()
+		if err != nil || !isDropped {
+			return dropped, err
+		}
+		dropped++
+	}
+	return dropped, nil
+}
+
+// DrainActivityTasks polls and drops up to count activity tasks, stops on first empty poll
+func (p *TaskPoller) DrainActivityTasks(count int) (dropped int, err error) {
+	for dropped < count {
+		isDropped, err := p.pollAndProcessActivityTask(true)
+		if err != nil || !isDropped {
+			return dropped, err
+		}
+		dropped++
+	}
+	return dropped, nil
+}
+
+// pollAndDropDecisionTask returns whether a decision task is received and dropped,
+// unlike PollAndProcessDecisionTask, empty poll is not retried
+func (p *TaskPoller) pollAndDropDecisionTask() (bool, error) {
+Loop:
+	for attempt := 0; attempt < 5; attempt++ {
+		response, err := p.Engine.PollForDecisionTask(p.newContext(), &workflowservice.PollForDecisionTaskRequest{
+			Namespace: p.Namespace,
+			TaskList:  p.TaskList,
+			Identity:  p.Identity,
+		})
+
+		if err == history.ErrDuplicate {
+			p.Logger.Info("Duplicate Decision task: Polling again")
+			continue Loop
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		if response == nil || len(response.TaskToken) == 0 {
+			p.Logger.Info("Empty Decision task: Stop draining")
+			return false, nil
+		}
+
+		p.Logger.Info("Dropping Decision task: ")
+		return true, nil
+	}
+
+	return false, matching.ErrNoTasks
+}
+
 func getQueryResults(queries map[string]*querypb.WorkflowQuery, queryResult *querypb.WorkflowQueryResult) map[string]*querypb.WorkflowQueryResult {
 	result := make(map[string]*querypb.WorkflowQueryResult)
 	for k := range queries {
@@ -491,20 +711,42 @@ func getQueryResults(queries map[string]*querypb.WorkflowQuery, queryResult *que
 	return result
 }
 
-func newApplicationFailure(err error, nonRetryable bool, details *commonpb.Payloads) *failurepb.Failure {
-	var applicationErr *temporal.ApplicationError
-	if errors.As(err, &applicationErr) {
-		nonRetryable = applicationErr.NonRetryable()
-	}
-
+// newFailure converts error returned by handler to failure, based on the SDK error type,
+// nonRetryable & details are used for application failure only
+func newFailure(err error, nonRetryable bool, details *commonpb.Payloads) *failurepb.Failure {
 	f := &failurepb.Failure{
 		Message: err.Error(),
 		Source:  "IntegrationTests",
-		FailureInfo: &failurepb.Failure_ApplicationFailureInfo{ApplicationFailureInfo: &failurepb.ApplicationFailureInfo{
+	}
+
+	var canceledErr *temporal.CanceledError
+	var timeoutErr *temporal.TimeoutError
+	var terminatedErr *temporal.TerminatedError
+	var panicErr *temporal.PanicError
+	var applicationErr *temporal.ApplicationError
+	switch {
+	case errors.As(err, &canceledErr):
+		f.FailureInfo = &failurepb.Failure_CanceledFailureInfo{CanceledFailureInfo: &failurepb.CanceledFailureInfo{}}
+	case errors.As(err, &timeoutErr):
+		f.FailureInfo = &failurepb.Failure_TimeoutFailureInfo{TimeoutFailureInfo: &failurepb.TimeoutFailureInfo{
+			TimeoutType: timeoutErr.TimeoutType(),
+		}}
+	case errors.As(err, &terminatedErr):
+		f.FailureInfo = &failurepb.Failure_TerminatedFailureInfo{TerminatedFailureInfo: &failurepb.TerminatedFailureInfo{}}
+	case errors.As(err, &panicErr):
+		f.StackTrace = panicErr.StackTrace()
+		f.FailureInfo = &failurepb.Failure_ApplicationFailureInfo{ApplicationFailureInfo: &failurepb.ApplicationFailureInfo{
+			Type: getErrorType(panicErr),
+		}}
+	default:
+		if errors.As(err, &applicationErr) {
+			nonRetryable = applicationErr.NonRetryable()
+		}
+		f.FailureInfo = &failurepb.Failure_ApplicationFailureInfo{ApplicationFailureInfo: &failurepb.ApplicationFailureInfo{
 			Type:         getErrorType(err),
 			NonRetryable: nonRetryable,
 			Details:      details,
-		}},
+		}}
 	}
 
 	return f
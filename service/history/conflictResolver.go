@@ -27,6 +27,8 @@
 package history
 
 import (
+	"fmt"
+
 	commonpb "go.temporal.io/temporal-proto/common/v1"
 	historypb "go.temporal.io/temporal-proto/history/v1"
 	"go.temporal.io/temporal-proto/serviceerror"
@@ -50,7 +52,23 @@ type (
 			replayEventID int64,
 			info *persistence.WorkflowExecutionInfo,
 			updateCondition int64,
-		) (mutableState, error)
+		) (mutableState, *conflictResolverResult, error)
+		resetToEventID(
+			prevRunID string,
+			prevLastWriteVersion int64,
+			prevState enumsgenpb.WorkflowExecutionState,
+			requestID string,
+			targetEventID int64,
+			info *persistence.WorkflowExecutionInfo,
+			updateCondition int64,
+		) (mutableState, *conflictResolverResult, error)
+	}
+
+	// conflictResolverResult contains the information about the history used to rebuild the mutable state
+	conflictResolverResult struct {
+		HistorySize int64
+		BranchToken []byte
+		LastEventID int64
 	}
 
 	conflictResolverImpl struct {
@@ -82,23 +100,78 @@ func (r *conflictResolverImpl) reset(
 	replayEventID int64,
 	info *persistence.WorkflowExecutionInfo,
 	updateCondition int64,
-) (mutableState, error) {
+) (mutableState, *conflictResolverResult, error) {
+
+	return r.resetToNextEventID(
+		prevRunID,
+		prevLastWriteVersion,
+		prevState,
+		requestID,
+		replayEventID+1,
+		info,
+		updateCondition,
+		nil,
+	)
+}
+
+// resetToEventID rebuilds the mutable state using history events before targetEventID,
+// targetEventID must be the decision finish event ID, i.e. DecisionTaskStarted event ID + 1
+func (r *conflictResolverImpl) resetToEventID(
+	prevRunID string,
+	prevLastWriteVersion int64,
+	prevState enumsgenpb.WorkflowExecutionState,
+	requestID string,
+	targetEventID int64,
+	info *persistence.WorkflowExecutionInfo,
+	updateCondition int64,
+) (mutableState, *conflictResolverResult, error) {
+
+	if targetEventID <= common.FirstEventID || targetEventID >= info.NextEventID {
+		return nil, nil, serviceerror.NewInvalidArgument(fmt.Sprintf(
+			"invalid reset target event ID: %v, next event ID: %v", targetEventID, info.NextEventID,
+		))
+	}
+
+	return r.resetToNextEventID(
+		prevRunID,
+		prevLastWriteVersion,
+		prevState,
+		requestID,
+		targetEventID,
+		info,
+		updateCondition,
+		func(lastBatch []*historypb.HistoryEvent) error {
+			return validateLastBatchOfReset(lastBatch, targetEventID)
+		},
+	)
+}
+
+func (r *conflictResolverImpl) resetToNextEventID(
+	prevRunID string,
+	prevLastWriteVersion int64,
+	prevState enumsgenpb.WorkflowExecutionState,
+	requestID string,
+	replayNextEventID int64,
+	info *persistence.WorkflowExecutionInfo,
+	updateCondition int64,
+	validateLastBatch func(lastBatch []*historypb.HistoryEvent) error,
+) (mutableState, *conflictResolverResult, error) {
 
 	namespaceID := r.context.getNamespaceID()
 	execution := *r.context.getExecution()
 	startTime := info.StartTimestamp
 	branchToken := info.BranchToken // in 2DC world branch token is stored in execution info
-	replayNextEventID := replayEventID + 1
 
 	namespaceEntry, err := r.shard.GetNamespaceCache().GetNamespaceByID(namespaceID)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var nextPageToken []byte
 	var resetMutableStateBuilder *mutableStateBuilder
 	var sBuilder stateBuilder
 	var history []*historypb.HistoryEvent
+	var lastBatch []*historypb.HistoryEvent
 	var totalSize int64
 
 	eventsToApply := replayNextEventID - common.FirstEventID
@@ -107,10 +180,12 @@ func (r *conflictResolverImpl) reset(
 		history, size, _, nextPageToken, err = r.getHistory(namespaceID, execution, common.FirstEventID, replayNextEventID, nextPageToken, branchToken)
 		if err != nil {
 			r.logError("Conflict resolution err getting history.", err)
-			return nil, err
+			return nil, nil, err
 		}
 
 		batchSize := int64(len(history))
+		// last batch is validated untrimmed, so a reset target in the middle of the batch is rejected
+		untrimmedHistory := history
 		// NextEventID could be in the middle of the batch.  Trim the history events to not have more events then what
 		// need to be applied
 		if batchSize > eventsToApply {
@@ -122,6 +197,7 @@ func (r *conflictResolverImpl) reset(
 		if len(history) == 0 {
 			break
 		}
+		lastBatch = untrimmedHistory
 
 		firstEvent := history[0]
 		if firstEvent.GetEventId() == common.FirstEventID {
@@ -139,19 +215,25 @@ func (r *conflictResolverImpl) reset(
 				func(mutableState mutableState) mutableStateTaskGenerator {
 					return newMutableStateTaskGenerator(r.shard.GetNamespaceCache(), r.logger, mutableState)
 				},
+				transactionPolicyPassive,
 			)
 		}
 
 		_, err = sBuilder.applyEvents(namespaceID, requestID, execution, history, nil, false)
 		if err != nil {
 			r.logError("Conflict resolution err applying events.", err)
-			return nil, err
+			return nil, nil, err
 		}
 		totalSize += int64(size)
 	}
 
 	if resetMutableStateBuilder == nil {
-		return nil, serviceerror.NewInvalidArgument("unable to create reset mutable state")
+		return nil, nil, serviceerror.NewInvalidArgument("unable to create reset mutable state")
+	}
+	if validateLastBatch != nil {
+		if err := validateLastBatch(lastBatch); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	// reset branchToken to the original one(it has been set to a wrong branchToken in applyEvents for startEvent)
@@ -168,7 +250,7 @@ func (r *conflictResolverImpl) reset(
 		transactionPolicyPassive,
 	)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	resetMutableStateBuilder.SetUpdateCondition(updateCondition)
@@ -196,7 +278,16 @@ func (r *conflictResolverImpl) reset(
 	); err != nil {
 		r.logError("Conflict resolution err reset workflow.", err)
 	}
-	return r.context.loadWorkflowExecution()
+
+	resetMutableState, err := r.context.loadWorkflowExecution()
+	if err != nil {
+		return nil, nil, err
+	}
+	return resetMutableState, &conflictResolverResult{
+		HistorySize: totalSize,
+		BranchToken: branchToken,
+		LastEventID: resetMutableStateBuilder.GetNextEventID() - 1,
+	}, nil
 }
 
 func (r *conflictResolverImpl) getHistory(namespaceID string, execution commonpb.WorkflowExecution, firstEventID,
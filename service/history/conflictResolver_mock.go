@@ -59,12 +59,13 @@ func (m *MockconflictResolver) EXPECT() *MockconflictResolverMockRecorder {
 }
 
 // reset mocks base method.
-func (m *MockconflictResolver) reset(prevRunID string, prevLastWriteVersion int64, prevState enums.WorkflowExecutionState, requestID string, replayEventID int64, info *persistence.WorkflowExecutionInfo, updateCondition int64) (mutableState, error) {
+func (m *MockconflictResolver) reset(prevRunID string, prevLastWriteVersion int64, prevState enums.WorkflowExecutionState, requestID string, replayEventID int64, info *persistence.WorkflowExecutionInfo, updateCondition int64) (mutableState, *conflictResolverResult, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "reset", prevRunID, prevLastWriteVersion, prevState, requestID, replayEventID, info, updateCondition)
 	ret0, _ := ret[0].(mutableState)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret1, _ := ret[1].(*conflictResolverResult)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
 }
 
 // reset indicates an expected call of reset.
@@ -72,3 +73,19 @@ func (mr *MockconflictResolverMockRecorder) reset(prevRunID, prevLastWriteVersio
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "reset", reflect.TypeOf((*MockconflictResolver)(nil).reset), prevRunID, prevLastWriteVersion, prevState, requestID, replayEventID, info, updateCondition)
 }
+
+// resetToEventID mocks base method.
+func (m *MockconflictResolver) resetToEventID(prevRunID string, prevLastWriteVersion int64, prevState enums.WorkflowExecutionState, requestID string, targetEventID int64, info *persistence.WorkflowExecutionInfo, updateCondition int64) (mutableState, *conflictResolverResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "resetToEventID", prevRunID, prevLastWriteVersion, prevState, requestID, targetEventID, info, updateCondition)
+	ret0, _ := ret[0].(mutableState)
+	ret1, _ := ret[1].(*conflictResolverResult)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// resetToEventID indicates an expected call of resetToEventID.
+func (mr *MockconflictResolverMockRecorder) resetToEventID(prevRunID, prevLastWriteVersion, prevState, requestID, targetEventID, info, updateCondition interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "resetToEventID", reflect.TypeOf((*MockconflictResolver)(nil).resetToEventID), prevRunID, prevLastWriteVersion, prevState, requestID, targetEventID, info, updateCondition)
+}
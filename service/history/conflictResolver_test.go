@@ -37,6 +37,7 @@ import (
 	commonpb "go.temporal.io/temporal-proto/common/v1"
 	enumspb "go.temporal.io/temporal-proto/enums/v1"
 	historypb "go.temporal.io/temporal-proto/history/v1"
+	"go.temporal.io/temporal-proto/serviceerror"
 	tasklistpb "go.temporal.io/temporal-proto/tasklist/v1"
 
 	enumsgenpb "github.com/temporalio/temporal/.gen/proto/enums/v1"
@@ -297,6 +298,234 @@ func (s *conflictResolverSuite) TestReset() {
 		&persistenceblobs.NamespaceInfo{Id: namespaceID}, &persistenceblobs.NamespaceConfig{}, "", nil,
 	), nil).AnyTimes()
 
-	_, err := s.conflictResolver.reset(prevRunID, prevLastWriteVersion, prevState, createRequestID, nextEventID-1, executionInfo, s.mockContext.updateCondition)
+	_, result, err := s.conflictResolver.reset(prevRunID, prevLastWriteVersion, prevState, createRequestID, nextEventID-1, executionInfo, s.mockContext.updateCondition)
 	s.Nil(err)
+	s.Equal(&conflictResolverResult{
+		HistorySize: historySize,
+		BranchToken: branchToken,
+		LastEventID: nextEventID - 1,
+	}, result)
+}
+
+func (s *conflictResolverSuite) TestResetToEventID() {
+	s.mockShard.config.AdvancedVisibilityWritingMode = dynamicconfig.GetStringPropertyFn(common.AdvancedVisibilityWritingModeDual)
+
+	prevRunID := uuid.New()
+	prevLastWriteVersion := int64(123)
+	prevState := enumsgenpb.WORKFLOW_EXECUTION_STATE_RUNNING
+
+	sourceCluster := cluster.TestAlternativeClusterName
+	startTime := time.Now()
+	version := int64(12)
+
+	namespaceID := s.mockContext.namespaceID
+	execution := s.mockContext.workflowExecution
+	nextEventID := int64(6)
+	targetEventID := int64(4)
+	branchToken := []byte("some random branch token")
+	tasklist := "some random tasklist"
+
+	event1 := &historypb.HistoryEvent{
+		EventId:   1,
+		Version:   version,
+		Timestamp: startTime.UnixNano(),
+		EventType: enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_STARTED,
+		Attributes: &historypb.HistoryEvent_WorkflowExecutionStartedEventAttributes{WorkflowExecutionStartedEventAttributes: &historypb.WorkflowExecutionStartedEventAttributes{
+			WorkflowType:                    &commonpb.WorkflowType{Name: "some random workflow type"},
+			TaskList:                        &tasklistpb.TaskList{Name: tasklist},
+			Input:                           payloads.EncodeString("some random input"),
+			WorkflowExecutionTimeoutSeconds: 123,
+			WorkflowRunTimeoutSeconds:       231,
+			WorkflowTaskTimeoutSeconds:      233,
+			Identity:                        "some random identity",
+		}},
+	}
+	event2 := &historypb.HistoryEvent{
+		EventId:   2,
+		Version:   version,
+		Timestamp: startTime.UnixNano(),
+		EventType: enumspb.EVENT_TYPE_DECISION_TASK_SCHEDULED,
+		Attributes: &historypb.HistoryEvent_DecisionTaskScheduledEventAttributes{DecisionTaskScheduledEventAttributes: &historypb.DecisionTaskScheduledEventAttributes{
+			TaskList:                   &tasklistpb.TaskList{Name: tasklist},
+			StartToCloseTimeoutSeconds: 233,
+		}},
+	}
+	event3 := &historypb.HistoryEvent{
+		EventId:   3,
+		Version:   version,
+		Timestamp: startTime.UnixNano(),
+		EventType: enumspb.EVENT_TYPE_DECISION_TASK_STARTED,
+		Attributes: &historypb.HistoryEvent_DecisionTaskStartedEventAttributes{DecisionTaskStartedEventAttributes: &historypb.DecisionTaskStartedEventAttributes{
+			ScheduledEventId: event2.GetEventId(),
+			RequestId:        uuid.New(),
+		}},
+	}
+
+	historySize := int64(1234567)
+	shardId := s.mockShard.GetShardID()
+	s.mockHistoryV2Mgr.On("ReadHistoryBranch", &persistence.ReadHistoryBranchRequest{
+		BranchToken:   branchToken,
+		MinEventID:    common.FirstEventID,
+		MaxEventID:    targetEventID,
+		PageSize:      defaultHistoryPageSize,
+		NextPageToken: nil,
+		ShardID:       &shardId,
+	}).Return(&persistence.ReadHistoryBranchResponse{
+		HistoryEvents:    []*historypb.HistoryEvent{event1, event2, event3},
+		NextPageToken:    nil,
+		LastFirstEventID: event1.GetEventId(),
+		Size:             int(historySize),
+	}, nil).Once()
+
+	s.mockContext.updateCondition = int64(59)
+	createRequestID := uuid.New()
+
+	executionInfo := &persistence.WorkflowExecutionInfo{
+		NamespaceID:    namespaceID,
+		WorkflowID:     execution.GetWorkflowId(),
+		RunID:          execution.GetRunId(),
+		NextEventID:    nextEventID,
+		StartTimestamp: startTime,
+		BranchToken:    branchToken,
+	}
+	s.mockExecutionMgr.On("ConflictResolveWorkflowExecution", mock.MatchedBy(func(input *persistence.ConflictResolveWorkflowExecutionRequest) bool {
+		resetExecutionInfo := input.ResetWorkflowSnapshot.ExecutionInfo
+		s.Equal(targetEventID, resetExecutionInfo.NextEventID)
+		s.Equal(event2.GetEventId(), resetExecutionInfo.DecisionScheduleID)
+		s.Equal(event3.GetEventId(), resetExecutionInfo.DecisionStartedID)
+		s.Equal(branchToken, resetExecutionInfo.BranchToken)
+		s.Equal(historySize, input.ResetWorkflowSnapshot.ExecutionStats.HistorySize)
+		s.Equal(s.mockContext.updateCondition, input.ResetWorkflowSnapshot.Condition)
+		s.Equal(&persistence.CurrentWorkflowCAS{
+			PrevRunID:            prevRunID,
+			PrevLastWriteVersion: prevLastWriteVersion,
+			PrevState:            prevState,
+		}, input.CurrentWorkflowCAS)
+		return true
+	})).Return(nil).Once()
+	s.mockExecutionMgr.On("GetWorkflowExecution", &persistence.GetWorkflowExecutionRequest{
+		NamespaceID: namespaceID,
+		Execution:   execution,
+	}).Return(&persistence.GetWorkflowExecutionResponse{
+		State: &persistence.WorkflowMutableState{
+			ExecutionInfo: &persistence.WorkflowExecutionInfo{
+				State:  enumsgenpb.WORKFLOW_EXECUTION_STATE_RUNNING,
+				Status: enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING,
+			},
+			ExecutionStats: &persistence.ExecutionStats{},
+		},
+	}, nil).Once() // return empty resoonse since we are not testing the load
+	s.mockClusterMetadata.EXPECT().IsGlobalNamespaceEnabled().Return(true).AnyTimes()
+	s.mockClusterMetadata.EXPECT().ClusterNameForFailoverVersion(event1.GetVersion()).Return(sourceCluster).AnyTimes()
+	s.mockNamespaceCache.EXPECT().GetNamespaceByID(gomock.Any()).Return(cache.NewLocalNamespaceCacheEntryForTest(
+		&persistenceblobs.NamespaceInfo{Id: namespaceID}, &persistenceblobs.NamespaceConfig{}, "", nil,
+	), nil).AnyTimes()
+
+	_, result, err := s.conflictResolver.resetToEventID(prevRunID, prevLastWriteVersion, prevState, createRequestID, targetEventID, executionInfo, s.mockContext.updateCondition)
+	s.Nil(err)
+	s.Equal(&conflictResolverResult{
+		HistorySize: historySize,
+		BranchToken: branchToken,
+		LastEventID: targetEventID - 1,
+	}, result)
+}
+
+func (s *conflictResolverSuite) TestResetToEventID_InvalidTargetEventID() {
+	version := int64(12)
+	namespaceID := s.mockContext.namespaceID
+	execution := s.mockContext.workflowExecution
+	nextEventID := int64(6)
+	branchToken := []byte("some random branch token")
+	tasklist := "some random tasklist"
+
+	event1 := &historypb.HistoryEvent{
+		EventId:   1,
+		Version:   version,
+		EventType: enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_STARTED,
+		Attributes: &historypb.HistoryEvent_WorkflowExecutionStartedEventAttributes{WorkflowExecutionStartedEventAttributes: &historypb.WorkflowExecutionStartedEventAttributes{
+			WorkflowType:                    &commonpb.WorkflowType{Name: "some random workflow type"},
+			TaskList:                        &tasklistpb.TaskList{Name: tasklist},
+			WorkflowExecutionTimeoutSeconds: 123,
+			WorkflowRunTimeoutSeconds:       231,
+			WorkflowTaskTimeoutSeconds:      233,
+		}},
+	}
+	event2 := &historypb.HistoryEvent{
+		EventId:   2,
+		Version:   version,
+		EventType: enumspb.EVENT_TYPE_DECISION_TASK_SCHEDULED,
+		Attributes: &historypb.HistoryEvent_DecisionTaskScheduledEventAttributes{DecisionTaskScheduledEventAttributes: &historypb.DecisionTaskScheduledEventAttributes{
+			TaskList:                   &tasklistpb.TaskList{Name: tasklist},
+			StartToCloseTimeoutSeconds: 233,
+		}},
+	}
+
+	event3 := &historypb.HistoryEvent{
+		EventId:   3,
+		Version:   version,
+		EventType: enumspb.EVENT_TYPE_DECISION_TASK_STARTED,
+		Attributes: &historypb.HistoryEvent_DecisionTaskStartedEventAttributes{DecisionTaskStartedEventAttributes: &historypb.DecisionTaskStartedEventAttributes{
+			ScheduledEventId: event2.GetEventId(),
+			RequestId:        uuid.New(),
+		}},
+	}
+	event4 := &historypb.HistoryEvent{
+		EventId:   4,
+		Version:   version,
+		EventType: enumspb.EVENT_TYPE_DECISION_TASK_COMPLETED,
+		Attributes: &historypb.HistoryEvent_DecisionTaskCompletedEventAttributes{DecisionTaskCompletedEventAttributes: &historypb.DecisionTaskCompletedEventAttributes{
+			ScheduledEventId: event2.GetEventId(),
+			StartedEventId:   event3.GetEventId(),
+		}},
+	}
+
+	// target event ID 3 is not a decision finish event ID, since the last replayed event is not decision started
+	shardId := s.mockShard.GetShardID()
+	s.mockHistoryV2Mgr.On("ReadHistoryBranch", &persistence.ReadHistoryBranchRequest{
+		BranchToken:   branchToken,
+		MinEventID:    common.FirstEventID,
+		MaxEventID:    int64(3),
+		PageSize:      defaultHistoryPageSize,
+		NextPageToken: nil,
+		ShardID:       &shardId,
+	}).Return(&persistence.ReadHistoryBranchResponse{
+		HistoryEvents:    []*historypb.HistoryEvent{event1, event2},
+		NextPageToken:    nil,
+		LastFirstEventID: event1.GetEventId(),
+		Size:             123,
+	}, nil).Once()
+	// target event ID 4 is in the middle of the batch 2 - 4, which is returned since it contains events before the target
+	s.mockHistoryV2Mgr.On("ReadHistoryBranch", &persistence.ReadHistoryBranchRequest{
+		BranchToken:   branchToken,
+		MinEventID:    common.FirstEventID,
+		MaxEventID:    int64(4),
+		PageSize:      defaultHistoryPageSize,
+		NextPageToken: nil,
+		ShardID:       &shardId,
+	}).Return(&persistence.ReadHistoryBranchResponse{
+		HistoryEvents:    []*historypb.HistoryEvent{event1, event2, event3, event4},
+		NextPageToken:    nil,
+		LastFirstEventID: event2.GetEventId(),
+		Size:             123,
+	}, nil).Once()
+	s.mockClusterMetadata.EXPECT().IsGlobalNamespaceEnabled().Return(true).AnyTimes()
+	s.mockClusterMetadata.EXPECT().ClusterNameForFailoverVersion(version).Return(cluster.TestAlternativeClusterName).AnyTimes()
+	s.mockNamespaceCache.EXPECT().GetNamespaceByID(gomock.Any()).Return(cache.NewLocalNamespaceCacheEntryForTest(
+		&persistenceblobs.NamespaceInfo{Id: namespaceID}, &persistenceblobs.NamespaceConfig{}, "", nil,
+	), nil).AnyTimes()
+
+	executionInfo := &persistence.WorkflowExecutionInfo{
+		NamespaceID:    namespaceID,
+		WorkflowID:     execution.GetWorkflowId(),
+		RunID:          execution.GetRunId(),
+		NextEventID:    nextEventID,
+		StartTimestamp: time.Now(),
+		BranchToken:    branchToken,
+	}
+	for _, targetEventID := range []int64{common.FirstEventID, 3, 4, nextEventID, nextEventID + 1} {
+		_, _, err := s.conflictResolver.resetToEventID(
+			uuid.New(), int64(123), enumsgenpb.WORKFLOW_EXECUTION_STATE_RUNNING, uuid.New(), targetEventID, executionInfo, int64(59),
+		)
+		s.IsType(&serviceerror.InvalidArgument{}, err)
+	}
 }
@@ -148,6 +148,7 @@ func newHistoryReplicator(
 				func(mutableState mutableState) mutableStateTaskGenerator {
 					return newMutableStateTaskGenerator(shard.GetNamespaceCache(), logger, mutableState)
 				},
+				transactionPolicyPassive,
 			)
 		},
 		getNewMutableState: func(namespaceEntry *cache.NamespaceCacheEntry, logger log.Logger) mutableState {
@@ -1067,7 +1068,7 @@ func (r *historyReplicator) resetMutableState(
 	}
 
 	resolver := r.getNewConflictResolver(context, logger)
-	msBuilder, err = resolver.reset(
+	msBuilder, _, err = resolver.reset(
 		currentRunID,
 		currentLastWriteVersion,
 		currentState,
@@ -106,11 +106,15 @@ type (
 
 		mutableState mutableState
 	}
+
+	// mutableStateTaskGeneratorNoop is task generator which does nothing
+	mutableStateTaskGeneratorNoop struct{}
 )
 
 const defaultWorkflowRetentionInDays int32 = 1
 
 var _ mutableStateTaskGenerator = (*mutableStateTaskGeneratorImpl)(nil)
+var _ mutableStateTaskGenerator = (*mutableStateTaskGeneratorNoop)(nil)
 
 func newMutableStateTaskGenerator(
 	namespaceCache cache.NamespaceCache,
@@ -536,3 +540,106 @@ func (r *mutableStateTaskGeneratorImpl) getTargetNamespaceID(
 
 	return targetNamespaceID, nil
 }
+
+func newMutableStateTaskGeneratorNoop() *mutableStateTaskGeneratorNoop {
+	return &mutableStateTaskGeneratorNoop{}
+}
+
+func (r *mutableStateTaskGeneratorNoop) generateWorkflowStartTasks(
+	_ time.Time,
+	_ *historypb.HistoryEvent,
+) error {
+	return nil
+}
+
+func (r *mutableStateTaskGeneratorNoop) generateWorkflowCloseTasks(
+	_ time.Time,
+) error {
+	return nil
+}
+
+func (r *mutableStateTaskGeneratorNoop) generateRecordWorkflowStartedTasks(
+	_ time.Time,
+	_ *historypb.HistoryEvent,
+) error {
+	return nil
+}
+
+func (r *mutableStateTaskGeneratorNoop) generateDelayedDecisionTasks(
+	_ time.Time,
+	_ *historypb.HistoryEvent,
+) error {
+	return nil
+}
+
+func (r *mutableStateTaskGeneratorNoop) generateDecisionScheduleTasks(
+	_ time.Time,
+	_ int64,
+) error {
+	return nil
+}
+
+func (r *mutableStateTaskGeneratorNoop) generateDecisionStartTasks(
+	_ time.Time,
+	_ int64,
+) error {
+	return nil
+}
+
+func (r *mutableStateTaskGeneratorNoop) generateActivityTransferTasks(
+	_ time.Time,
+	_ *historypb.HistoryEvent,
+) error {
+	return nil
+}
+
+func (r *mutableStateTaskGeneratorNoop) generateActivityRetryTasks(
+	_ int64,
+) error {
+	return nil
+}
+
+func (r *mutableStateTaskGeneratorNoop) generateChildWorkflowTasks(
+	_ time.Time,
+	_ *historypb.HistoryEvent,
+) error {
+	return nil
+}
+
+func (r *mutableStateTaskGeneratorNoop) generateRequestCancelExternalTasks(
+	_ time.Time,
+	_ *historypb.HistoryEvent,
+) error {
+	return nil
+}
+
+func (r *mutableStateTaskGeneratorNoop) generateSignalExternalTasks(
+	_ time.Time,
+	_ *historypb.HistoryEvent,
+) error {
+	return nil
+}
+
+func (r *mutableStateTaskGeneratorNoop) generateWorkflowSearchAttrTasks(
+	_ time.Time,
+) error {
+	return nil
+}
+
+func (r *mutableStateTaskGeneratorNoop) generateWorkflowResetTasks(
+	_ time.Time,
+) error {
+	return nil
+}
+
+func (r *mutableStateTaskGeneratorNoop) generateActivityTimerTasks(
+	_ time.Time,
+) error {
+	return nil
+}
+
+func (r *mutableStateTaskGeneratorNoop) generateUserTimerTasks(
+	_ time.Time,
+) error {
+	return nil
+}
@@ -66,7 +66,7 @@ func newNDCConflictResolver(
 
 	return &nDCConflictResolverImpl{
 		shard:          shard,
-		stateRebuilder: newNDCStateRebuilder(shard, logger),
+		stateRebuilder: newNDCStateRebuilder(shard, logger, transactionPolicyPassive),
 
 		context:      context,
 		mutableState: mutableState,
@@ -169,6 +169,7 @@ func newNDCHistoryReplicator(
 				func(mutableState mutableState) mutableStateTaskGenerator {
 					return newMutableStateTaskGenerator(shard.GetNamespaceCache(), logger, mutableState)
 				},
+				transactionPolicyPassive,
 			)
 		},
 		newMutableState: func(
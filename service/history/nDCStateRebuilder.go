@@ -69,6 +69,9 @@ type (
 		historyV2Mgr    persistence.HistoryManager
 		taskRefresher   mutableStateTaskRefresher
 
+		// transactionPolicy is used by the state builder, stickiness is only cleared when passive
+		transactionPolicy transactionPolicy
+
 		rebuiltHistorySize int64
 		logger             log.Logger
 	}
@@ -79,6 +82,7 @@ var _ nDCStateRebuilder = (*nDCStateRebuilderImpl)(nil)
 func newNDCStateRebuilder(
 	shard ShardContext,
 	logger log.Logger,
+	transactionPolicy transactionPolicy,
 ) *nDCStateRebuilderImpl {
 
 	return &nDCStateRebuilderImpl{
@@ -93,6 +97,7 @@ func newNDCStateRebuilder(
 			shard.GetEventsCache(),
 			logger,
 		),
+		transactionPolicy:  transactionPolicy,
 		rebuiltHistorySize: 0,
 		logger:             logger,
 	}
@@ -196,6 +201,7 @@ func (r *nDCStateRebuilderImpl) initializeBuilders(
 		func(mutableState mutableState) mutableStateTaskGenerator {
 			return newMutableStateTaskGenerator(r.shard.GetNamespaceCache(), r.logger, mutableState)
 		},
+		r.transactionPolicy,
 	)
 	return resetMutableStateBuilder, stateBuilder
 }
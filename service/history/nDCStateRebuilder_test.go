@@ -107,7 +107,7 @@ func (s *nDCStateRebuilderSuite) SetupTest() {
 	s.workflowID = "some random workflow ID"
 	s.runID = uuid.New()
 	s.nDCStateRebuilder = newNDCStateRebuilder(
-		s.mockShard, s.logger,
+		s.mockShard, s.logger, transactionPolicyPassive,
 	)
 	s.nDCStateRebuilder.taskRefresher = s.mockTaskRefresher
 }
@@ -122,6 +122,16 @@ func (s *nDCStateRebuilderSuite) TestInitializeBuilders() {
 	s.NotNil(mutableState)
 	s.NotNil(stateBuilder)
 	s.NotNil(mutableState.GetVersionHistories())
+	s.Equal(transactionPolicyPassive, stateBuilder.(*stateBuilderImpl).transactionPolicy)
+}
+
+func (s *nDCStateRebuilderSuite) TestInitializeBuilders_ActiveTransactionPolicy() {
+	s.nDCStateRebuilder.transactionPolicy = transactionPolicyActive
+
+	mutableState, stateBuilder := s.nDCStateRebuilder.initializeBuilders(testGlobalNamespaceEntry)
+	s.NotNil(mutableState)
+	s.NotNil(stateBuilder)
+	s.Equal(transactionPolicyActive, stateBuilder.(*stateBuilderImpl).transactionPolicy)
 }
 
 func (s *nDCStateRebuilderSuite) TestApplyEvents() {
@@ -88,7 +88,7 @@ func newNDCWorkflowResetter(
 		shard:          shard,
 		transactionMgr: transactionMgr,
 		historyV2Mgr:   shard.GetHistoryManager(),
-		stateRebuilder: newNDCStateRebuilder(shard, logger),
+		stateRebuilder: newNDCStateRebuilder(shard, logger, transactionPolicyPassive),
 
 		namespaceID: namespaceID,
 		workflowID:  workflowID,
@@ -27,6 +27,7 @@
 package history
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/pborman/uuid"
@@ -35,15 +36,19 @@ import (
 	historypb "go.temporal.io/temporal-proto/history/v1"
 	"go.temporal.io/temporal-proto/serviceerror"
 
+	"github.com/temporalio/temporal/common"
 	"github.com/temporalio/temporal/common/cache"
 	"github.com/temporalio/temporal/common/cluster"
 	"github.com/temporalio/temporal/common/log"
+	"github.com/temporalio/temporal/common/log/tag"
 	"github.com/temporalio/temporal/common/persistence"
 )
 
 type (
 	taskGeneratorProvider func(mutableState) mutableStateTaskGenerator
 
+	eventProcessedCallback func(eventType enumspb.EventType, eventID int64, err error)
+
 	stateBuilder interface {
 		applyEvents(
 			namespaceID string,
@@ -53,6 +58,14 @@ type (
 			newRunHistory []*historypb.HistoryEvent,
 			newRunNDC bool,
 		) (mutableState, error)
+		applyEventsAndCollectTasks(
+			namespaceID string,
+			requestID string,
+			execution commonpb.WorkflowExecution,
+			history []*historypb.HistoryEvent,
+			newRunHistory []*historypb.HistoryEvent,
+			newRunNDC bool,
+		) (mutableState, *stateBuilderTasks, error)
 	}
 
 	stateBuilderImpl struct {
@@ -63,6 +76,41 @@ type (
 
 		mutableState          mutableState
 		taskGeneratorProvider taskGeneratorProvider
+		transactionPolicy     transactionPolicy
+		options               stateBuilderOptions
+		taskCollector         *stateBuilderTaskCollector
+	}
+
+	stateBuilderOptions struct {
+		// SkipTaskGeneration indicates that events are only replayed into mutable state,
+		// no transfer / timer tasks will be generated
+		SkipTaskGeneration bool
+		// IgnorableEventTypes contains the event types which are not handled by state builder,
+		// but should be skipped instead of failing the whole batch
+		IgnorableEventTypes map[enumspb.EventType]struct{}
+		// EventProcessedCallback, if set, is invoked after each event of the current run is applied,
+		// with the error (if any) returned when applying the event
+		EventProcessedCallback eventProcessedCallback
+	}
+
+	// stateBuilderTasks contains the tasks generated when applying a batch of events,
+	// keyed by the ID of the event which generated the tasks, except activity / user timer tasks,
+	// which are generated at the end of the batch and keyed by the event ID carried by the task,
+	// i.e. activity scheduled / timer started event ID.
+	// NOTE: replication tasks are not generated by state builder but when the mutable state
+	// transaction is closed (CloseTransactionAsMutation / CloseTransactionAsSnapshot),
+	// so they are not collected here
+	stateBuilderTasks struct {
+		TransferTasks map[int64][]persistence.Task
+		TimerTasks    map[int64][]persistence.Task
+	}
+
+	// stateBuilderTaskCollector records the tasks added to the underlying mutable state
+	stateBuilderTaskCollector struct {
+		mutableState
+
+		eventID int64
+		tasks   *stateBuilderTasks
 	}
 )
 
@@ -78,8 +126,34 @@ func newStateBuilder(
 	logger log.Logger,
 	mutableState mutableState,
 	taskGeneratorProvider taskGeneratorProvider,
+	transactionPolicy transactionPolicy,
 ) *stateBuilderImpl {
 
+	return newStateBuilderWithOptions(
+		shard,
+		logger,
+		mutableState,
+		taskGeneratorProvider,
+		transactionPolicy,
+		stateBuilderOptions{},
+	)
+}
+
+func newStateBuilderWithOptions(
+	shard ShardContext,
+	logger log.Logger,
+	mutableState mutableState,
+	taskGeneratorProvider taskGeneratorProvider,
+	transactionPolicy transactionPolicy,
+	options stateBuilderOptions,
+) *stateBuilderImpl {
+
+	if options.SkipTaskGeneration {
+		taskGeneratorProvider = func(_ mutableState) mutableStateTaskGenerator {
+			return newMutableStateTaskGeneratorNoop()
+		}
+	}
+
 	return &stateBuilderImpl{
 		shard:                 shard,
 		clusterMetadata:       shard.GetService().GetClusterMetadata(),
@@ -87,6 +161,8 @@ func newStateBuilder(
 		logger:                logger,
 		mutableState:          mutableState,
 		taskGeneratorProvider: taskGeneratorProvider,
+		transactionPolicy:     transactionPolicy,
+		options:               options,
 	}
 }
 
@@ -97,21 +173,40 @@ func (b *stateBuilderImpl) applyEvents(
 	history []*historypb.HistoryEvent,
 	newRunHistory []*historypb.HistoryEvent,
 	newRunNDC bool,
-) (mutableState, error) {
+) (retMutableState mutableState, retErr error) {
 
 	if len(history) == 0 {
 		return nil, serviceerror.NewInternal(ErrMessageHistorySizeZero)
 	}
+	if err := b.validateEvents(history); err != nil {
+		return nil, err
+	}
 	firstEvent := history[0]
 	lastEvent := history[len(history)-1]
 	var newRunMutableStateBuilder mutableState
 
-	taskGenerator := b.taskGeneratorProvider(b.mutableState)
+	var taskGenerator mutableStateTaskGenerator
+	if b.taskCollector != nil {
+		taskGenerator = b.taskGeneratorProvider(b.taskCollector)
+	} else {
+		taskGenerator = b.taskGeneratorProvider(b.mutableState)
+	}
 
 	// need to clear the stickiness since workflow turned to passive
-	b.mutableState.ClearStickyness()
+	if b.transactionPolicy == transactionPolicyPassive {
+		b.mutableState.ClearStickyness()
+	}
+
+	// processedEvent is the event being applied, reported to the callback if applying it fails
+	var processedEvent *historypb.HistoryEvent
+	defer func() {
+		if retErr != nil && processedEvent != nil && b.options.EventProcessedCallback != nil {
+			b.options.EventProcessedCallback(processedEvent.GetEventType(), processedEvent.GetEventId(), retErr)
+		}
+	}()
 
 	for _, event := range history {
+		processedEvent = event
 		// NOTE: stateBuilder is also being used in the active side
 		if b.mutableState.GetReplicationState() != nil {
 			// this function must be called within the for loop, in case
@@ -135,6 +230,9 @@ func (b *stateBuilderImpl) applyEvents(
 			}
 		}
 		b.mutableState.GetExecutionInfo().LastEventTaskID = event.GetTaskId()
+		if b.taskCollector != nil {
+			b.taskCollector.eventID = event.GetEventId()
+		}
 
 		switch event.GetEventType() {
 		case enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_STARTED:
@@ -211,7 +309,7 @@ func (b *stateBuilderImpl) applyEvents(
 
 			// since we do not use stickiness on the standby side
 			// there shall be no decision schedule to start timeout
-			// NOTE: at the beginning of the loop, stickyness is cleared
+			// NOTE: with passive transaction policy, stickyness is cleared before the loop
 			if err := taskGenerator.generateDecisionScheduleTasks(
 				b.unixNanoToTime(event.GetTimestamp()),
 				decision.ScheduleID,
@@ -263,7 +361,7 @@ func (b *stateBuilderImpl) applyEvents(
 			if decision != nil {
 				// since we do not use stickiness on the standby side
 				// there shall be no decision schedule to start timeout
-				// NOTE: at the beginning of the loop, stickyness is cleared
+				// NOTE: with passive transaction policy, stickyness is cleared before the loop
 				if err := taskGenerator.generateDecisionScheduleTasks(
 					b.unixNanoToTime(event.GetTimestamp()),
 					decision.ScheduleID,
@@ -286,7 +384,7 @@ func (b *stateBuilderImpl) applyEvents(
 			if decision != nil {
 				// since we do not use stickiness on the standby side
 				// there shall be no decision schedule to start timeout
-				// NOTE: at the beginning of the loop, stickyness is cleared
+				// NOTE: with passive transaction policy, stickyness is cleared before the loop
 				if err := taskGenerator.generateDecisionScheduleTasks(
 					b.unixNanoToTime(event.GetTimestamp()),
 					decision.ScheduleID,
@@ -624,20 +722,32 @@ func (b *stateBuilderImpl) applyEvents(
 						b.mutableState.GetNamespaceEntry(),
 					)
 				}
-				newRunStateBuilder := newStateBuilder(b.shard, b.logger, newRunMutableStateBuilder, b.taskGeneratorProvider)
+				// callback only reports the events of the current run
+				newRunOptions := b.options
+				newRunOptions.EventProcessedCallback = nil
+				newRunStateBuilder := newStateBuilderWithOptions(
+					b.shard,
+					b.logger,
+					newRunMutableStateBuilder,
+					b.taskGeneratorProvider,
+					b.transactionPolicy,
+					newRunOptions,
+				)
 
 				newRunID := event.GetWorkflowExecutionContinuedAsNewEventAttributes().GetNewExecutionRunId()
 				newExecution := commonpb.WorkflowExecution{
 					WorkflowId: execution.WorkflowId,
 					RunId:      newRunID,
 				}
+				// NOTE: newRunNDC is passed for consistency only, the nested call has no new run history,
+				// version histories / replication state are updated based on the new run mutable state type
 				_, err := newRunStateBuilder.applyEvents(
 					namespaceID,
 					uuid.New(),
 					newExecution,
 					newRunHistory,
 					nil,
-					false,
+					newRunNDC,
 				)
 				if err != nil {
 					return nil, err
@@ -660,11 +770,25 @@ func (b *stateBuilderImpl) applyEvents(
 			}
 
 		default:
-			return nil, serviceerror.NewInvalidArgument("Unknown event type: %v").MessageArgs(event.GetEventType())
+			if _, ok := b.options.IgnorableEventTypes[event.GetEventType()]; !ok {
+				return nil, serviceerror.NewInvalidArgument("Unknown event type: %v").MessageArgs(event.GetEventType())
+			}
+			b.logger.Warn("stateBuilder skipping unknown event type",
+				tag.WorkflowEventID(event.GetEventId()),
+				tag.WorkflowEventType(event.GetEventType()),
+			)
+		}
+
+		if b.options.EventProcessedCallback != nil {
+			b.options.EventProcessedCallback(event.GetEventType(), event.GetEventId(), nil)
 		}
 	}
+	processedEvent = nil
 
 	// must generate the activity timer / user timer at the very end
+	// NOTE: timer fire times are derived from the timestamps recorded in mutable state
+	// by each event's replicate call (activity scheduled / started time, user timer expiry time),
+	// so anchoring the generation to the last event timestamp does not skew them
 	if err := taskGenerator.generateActivityTimerTasks(
 		b.unixNanoToTime(lastEvent.GetTimestamp()),
 	); err != nil {
@@ -684,9 +808,114 @@ func (b *stateBuilderImpl) applyEvents(
 	return newRunMutableStateBuilder, nil
 }
 
+func (b *stateBuilderImpl) applyEventsAndCollectTasks(
+	namespaceID string,
+	requestID string,
+	execution commonpb.WorkflowExecution,
+	history []*historypb.HistoryEvent,
+	newRunHistory []*historypb.HistoryEvent,
+	newRunNDC bool,
+) (mutableState, *stateBuilderTasks, error) {
+
+	// NOTE: tasks generated for the new run (continue as new) are not collected
+	b.taskCollector = newStateBuilderTaskCollector(b.mutableState)
+	defer func() { b.taskCollector = nil }()
+
+	newRunMutableState, err := b.applyEvents(
+		namespaceID,
+		requestID,
+		execution,
+		history,
+		newRunHistory,
+		newRunNDC,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newRunMutableState, b.taskCollector.tasks, nil
+}
+
+func (b *stateBuilderImpl) validateEvents(
+	history []*historypb.HistoryEvent,
+) error {
+
+	firstEvent := history[0]
+	// NextEventID is not yet initialized when the workflow is being started
+	nextEventID := b.mutableState.GetExecutionInfo().NextEventID
+	if firstEvent.GetEventType() != enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_STARTED &&
+		nextEventID != 0 &&
+		firstEvent.GetEventId() != nextEventID {
+		return serviceerror.NewInvalidArgument(fmt.Sprintf(
+			"event ID gap detected, first event ID: %v, next event ID: %v",
+			firstEvent.GetEventId(),
+			nextEventID,
+		))
+	}
+
+	prevEvent := firstEvent
+	for _, event := range history[1:] {
+		if event.GetEventId() != prevEvent.GetEventId()+1 {
+			return serviceerror.NewInvalidArgument(fmt.Sprintf(
+				"event ID is not contiguous, event ID: %v, previous event ID: %v",
+				event.GetEventId(),
+				prevEvent.GetEventId(),
+			))
+		}
+		if event.GetVersion() < prevEvent.GetVersion() {
+			return serviceerror.NewInvalidArgument(fmt.Sprintf(
+				"event version goes backward, event ID: %v, version: %v, previous version: %v",
+				event.GetEventId(),
+				event.GetVersion(),
+				prevEvent.GetVersion(),
+			))
+		}
+		prevEvent = event
+	}
+	return nil
+}
+
 func (b *stateBuilderImpl) unixNanoToTime(
 	unixNano int64,
 ) time.Time {
 
 	return time.Unix(0, unixNano)
 }
+
+func newStateBuilderTaskCollector(
+	mutableState mutableState,
+) *stateBuilderTaskCollector {
+
+	return &stateBuilderTaskCollector{
+		mutableState: mutableState,
+		eventID:      common.EmptyEventID,
+		tasks: &stateBuilderTasks{
+			TransferTasks: make(map[int64][]persistence.Task),
+			TimerTasks:    make(map[int64][]persistence.Task),
+		},
+	}
+}
+
+func (c *stateBuilderTaskCollector) AddTransferTasks(
+	transferTasks ...persistence.Task,
+) {
+
+	c.tasks.TransferTasks[c.eventID] = append(c.tasks.TransferTasks[c.eventID], transferTasks...)
+	c.mutableState.AddTransferTasks(transferTasks...)
+}
+
+func (c *stateBuilderTaskCollector) AddTimerTasks(
+	timerTasks ...persistence.Task,
+) {
+
+	for _, task := range timerTasks {
+		eventID := c.eventID
+		switch timerTask := task.(type) {
+		case *persistence.ActivityTimeoutTask:
+			eventID = timerTask.EventID
+		case *persistence.UserTimerTask:
+			eventID = timerTask.EventID
+		}
+		c.tasks.TimerTasks[eventID] = append(c.tasks.TimerTasks[eventID], task)
+	}
+	c.mutableState.AddTimerTasks(timerTasks...)
+}
@@ -72,3 +72,19 @@ func (mr *MockstateBuilderMockRecorder) applyEvents(namespaceID, requestID, exec
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "applyEvents", reflect.TypeOf((*MockstateBuilder)(nil).applyEvents), namespaceID, requestID, execution, history, newRunHistory, newRunNDC)
 }
+
+// applyEventsAndCollectTasks mocks base method.
+func (m *MockstateBuilder) applyEventsAndCollectTasks(namespaceID, requestID string, execution common.WorkflowExecution, history, newRunHistory []*history.HistoryEvent, newRunNDC bool) (mutableState, *stateBuilderTasks, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "applyEventsAndCollectTasks", namespaceID, requestID, execution, history, newRunHistory, newRunNDC)
+	ret0, _ := ret[0].(mutableState)
+	ret1, _ := ret[1].(*stateBuilderTasks)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// applyEventsAndCollectTasks indicates an expected call of applyEventsAndCollectTasks.
+func (mr *MockstateBuilderMockRecorder) applyEventsAndCollectTasks(namespaceID, requestID, execution, history, newRunHistory, newRunNDC interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "applyEventsAndCollectTasks", reflect.TypeOf((*MockstateBuilder)(nil).applyEventsAndCollectTasks), namespaceID, requestID, execution, history, newRunHistory, newRunNDC)
+}
@@ -36,6 +36,7 @@ import (
 	commonpb "go.temporal.io/temporal-proto/common/v1"
 	enumspb "go.temporal.io/temporal-proto/enums/v1"
 	historypb "go.temporal.io/temporal-proto/history/v1"
+	"go.temporal.io/temporal-proto/serviceerror"
 	tasklistpb "go.temporal.io/temporal-proto/tasklist/v1"
 
 	"github.com/temporalio/temporal/.gen/proto/persistenceblobs/v1"
@@ -122,6 +123,7 @@ func (s *stateBuilderSuite) SetupTest() {
 			}
 			return s.mockTaskGeneratorForNew
 		},
+		transactionPolicyPassive,
 	)
 	s.sourceCluster = "some random source cluster"
 }
@@ -519,6 +521,138 @@ func (s *stateBuilderSuite) TestApplyEvents_EventTypeWorkflowExecutionContinuedA
 	s.NotNil(newRunStateBuilder)
 }
 
+func (s *stateBuilderSuite) TestApplyEvents_EventTypeWorkflowExecutionContinuedAsNew_NewRunNDC() {
+	version := int64(1)
+	requestID := uuid.New()
+	execution := commonpb.WorkflowExecution{
+		WorkflowId: "some random workflow ID",
+		RunId:      testRunID,
+	}
+	parentWorkflowID := "some random parent workflow ID"
+	parentRunID := uuid.New()
+	parentInitiatedEventID := int64(144)
+
+	now := time.Now()
+	tasklist := "some random tasklist"
+	workflowType := "some random workflow type"
+	workflowTimeoutSecond := int32(110)
+	taskTimeoutSeconds := int32(11)
+	newRunID := uuid.New()
+
+	continueAsNewEvent := &historypb.HistoryEvent{
+		Version:   version,
+		EventId:   130,
+		Timestamp: now.UnixNano(),
+		EventType: enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_CONTINUED_AS_NEW,
+		Attributes: &historypb.HistoryEvent_WorkflowExecutionContinuedAsNewEventAttributes{WorkflowExecutionContinuedAsNewEventAttributes: &historypb.WorkflowExecutionContinuedAsNewEventAttributes{
+			NewExecutionRunId: newRunID,
+		}},
+	}
+
+	newRunStartedEvent := &historypb.HistoryEvent{
+		Version:   version,
+		EventId:   1,
+		Timestamp: now.UnixNano(),
+		EventType: enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_STARTED,
+		Attributes: &historypb.HistoryEvent_WorkflowExecutionStartedEventAttributes{WorkflowExecutionStartedEventAttributes: &historypb.WorkflowExecutionStartedEventAttributes{
+			ParentWorkflowNamespace: testParentNamespace,
+			ParentWorkflowExecution: &commonpb.WorkflowExecution{
+				WorkflowId: parentWorkflowID,
+				RunId:      parentRunID,
+			},
+			ParentInitiatedEventId:          parentInitiatedEventID,
+			WorkflowExecutionTimeoutSeconds: workflowTimeoutSecond,
+			WorkflowTaskTimeoutSeconds:      taskTimeoutSeconds,
+			TaskList:                        &tasklistpb.TaskList{Name: tasklist},
+			WorkflowType:                    &commonpb.WorkflowType{Name: workflowType},
+		}},
+	}
+
+	newRunSignalEvent := &historypb.HistoryEvent{
+		Version:   version,
+		EventId:   2,
+		Timestamp: now.UnixNano(),
+		EventType: enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_SIGNALED,
+		Attributes: &historypb.HistoryEvent_WorkflowExecutionSignaledEventAttributes{WorkflowExecutionSignaledEventAttributes: &historypb.WorkflowExecutionSignaledEventAttributes{
+			SignalName: "some random signal name",
+			Input:      payloads.EncodeString("some random signal input"),
+			Identity:   "some random identity",
+		}},
+	}
+
+	newRunDecisionAttempt := int64(123)
+	newRunDecisionEvent := &historypb.HistoryEvent{
+		Version:   version,
+		EventId:   3,
+		Timestamp: now.UnixNano(),
+		EventType: enumspb.EVENT_TYPE_DECISION_TASK_SCHEDULED,
+		Attributes: &historypb.HistoryEvent_DecisionTaskScheduledEventAttributes{DecisionTaskScheduledEventAttributes: &historypb.DecisionTaskScheduledEventAttributes{
+			TaskList:                   &tasklistpb.TaskList{Name: tasklist},
+			StartToCloseTimeoutSeconds: taskTimeoutSeconds,
+			Attempt:                    newRunDecisionAttempt,
+		}},
+	}
+	newRunEvents := []*historypb.HistoryEvent{
+		newRunStartedEvent, newRunSignalEvent, newRunDecisionEvent,
+	}
+
+	s.mockClusterMetadata.EXPECT().ClusterNameForFailoverVersion(continueAsNewEvent.GetVersion()).Return(s.sourceCluster).AnyTimes()
+	s.mockMutableState.EXPECT().ReplicateWorkflowExecutionContinuedAsNewEvent(
+		continueAsNewEvent.GetEventId(),
+		testNamespaceID,
+		continueAsNewEvent,
+	).Return(nil).Times(1)
+	s.mockMutableState.EXPECT().GetNamespaceEntry().Return(testGlobalNamespaceEntry).AnyTimes()
+	s.mockUpdateVersion(continueAsNewEvent)
+	s.mockMutableState.EXPECT().GetExecutionInfo().Return(&persistence.WorkflowExecutionInfo{}).AnyTimes()
+	s.mockTaskGenerator.EXPECT().generateWorkflowCloseTasks(
+		s.stateBuilder.unixNanoToTime(continueAsNewEvent.GetTimestamp()),
+	).Return(nil).Times(1)
+	s.mockMutableState.EXPECT().ClearStickyness().Times(1)
+
+	// new workflow namespace
+	s.mockNamespaceCache.EXPECT().GetNamespace(testParentNamespace).Return(testGlobalParentNamespaceEntry, nil).AnyTimes()
+	// task for the new workflow
+	s.mockTaskGeneratorForNew.EXPECT().generateRecordWorkflowStartedTasks(
+		s.stateBuilder.unixNanoToTime(newRunStartedEvent.GetTimestamp()),
+		newRunStartedEvent,
+	).Return(nil).Times(1)
+	s.mockTaskGeneratorForNew.EXPECT().generateWorkflowStartTasks(
+		s.stateBuilder.unixNanoToTime(newRunStartedEvent.GetTimestamp()),
+		newRunStartedEvent,
+	).Return(nil).Times(1)
+	s.mockTaskGeneratorForNew.EXPECT().generateDecisionScheduleTasks(
+		s.stateBuilder.unixNanoToTime(newRunDecisionEvent.GetTimestamp()),
+		newRunDecisionEvent.GetEventId(),
+	).Return(nil).Times(1)
+	s.mockTaskGeneratorForNew.EXPECT().generateActivityTimerTasks(
+		s.stateBuilder.unixNanoToTime(newRunEvents[len(newRunEvents)-1].GetTimestamp()),
+	).Return(nil).Times(1)
+	s.mockTaskGeneratorForNew.EXPECT().generateUserTimerTasks(
+		s.stateBuilder.unixNanoToTime(newRunEvents[len(newRunEvents)-1].GetTimestamp()),
+	).Return(nil).Times(1)
+
+	// callback should not be invoked for the events of the new run
+	var processedEventTypes []enumspb.EventType
+	s.stateBuilder.options.EventProcessedCallback = func(eventType enumspb.EventType, eventID int64, err error) {
+		processedEventTypes = append(processedEventTypes, eventType)
+	}
+
+	newRunStateBuilder, err := s.stateBuilder.applyEvents(
+		testNamespaceID, requestID, execution, s.toHistory(continueAsNewEvent), newRunEvents, true,
+	)
+	s.Nil(err)
+	s.Equal([]enumspb.EventType{enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_CONTINUED_AS_NEW}, processedEventTypes)
+	s.NotNil(newRunStateBuilder)
+	s.Nil(newRunStateBuilder.GetReplicationState())
+	s.NotNil(newRunStateBuilder.GetVersionHistories())
+	currentVersionHistory, err := newRunStateBuilder.GetVersionHistories().GetCurrentVersionHistory()
+	s.NoError(err)
+	lastItem, err := currentVersionHistory.GetLastItem()
+	s.NoError(err)
+	s.Equal(persistence.NewVersionHistoryItem(newRunDecisionEvent.GetEventId(), version), lastItem)
+}
+
 func (s *stateBuilderSuite) TestApplyEvents_EventTypeWorkflowExecutionContinuedAsNew_EmptyNewRunHistory() {
 	version := int64(1)
 	requestID := uuid.New()
@@ -1746,6 +1880,687 @@ func (s *stateBuilderSuite) TestApplyEvents_EventTypeExternalWorkflowExecutionSi
 	s.Nil(err)
 }
 
+func (s *stateBuilderSuite) TestApplyEvents_ClearStickyness() {
+	version := int64(1)
+	requestID := uuid.New()
+	execution := commonpb.WorkflowExecution{
+		WorkflowId: "some random workflow ID",
+		RunId:      testRunID,
+	}
+
+	now := time.Now()
+	event := &historypb.HistoryEvent{
+		Version:    version,
+		EventId:    130,
+		Timestamp:  now.UnixNano(),
+		EventType:  enumspb.EVENT_TYPE_MARKER_RECORDED,
+		Attributes: &historypb.HistoryEvent_MarkerRecordedEventAttributes{MarkerRecordedEventAttributes: &historypb.MarkerRecordedEventAttributes{}},
+	}
+
+	testCases := []struct {
+		transactionPolicy  transactionPolicy
+		clearStickynessCnt int
+	}{
+		{transactionPolicy: transactionPolicyActive, clearStickynessCnt: 0},
+		{transactionPolicy: transactionPolicyPassive, clearStickynessCnt: 1},
+	}
+
+	for _, tc := range testCases {
+		stateBuilder := newStateBuilder(
+			s.mockShard,
+			s.logger,
+			s.mockMutableState,
+			func(mutableState mutableState) mutableStateTaskGenerator {
+				return s.mockTaskGenerator
+			},
+			tc.transactionPolicy,
+		)
+
+		s.mockUpdateVersion(event)
+		s.mockMutableState.EXPECT().GetExecutionInfo().Return(&persistence.WorkflowExecutionInfo{}).AnyTimes()
+		s.mockMutableState.EXPECT().ClearStickyness().Times(tc.clearStickynessCnt)
+
+		_, err := stateBuilder.applyEvents(testNamespaceID, requestID, execution, s.toHistory(event), nil, false)
+		s.Nil(err)
+	}
+}
+
+func (s *stateBuilderSuite) TestApplyEvents_SkipTaskGeneration() {
+	version := int64(1)
+	requestID := uuid.New()
+	execution := commonpb.WorkflowExecution{
+		WorkflowId: "some random workflow ID",
+		RunId:      testRunID,
+	}
+
+	now := time.Now()
+	activityScheduledEvent := &historypb.HistoryEvent{
+		Version:    version,
+		EventId:    130,
+		Timestamp:  now.UnixNano(),
+		EventType:  enumspb.EVENT_TYPE_ACTIVITY_TASK_SCHEDULED,
+		Attributes: &historypb.HistoryEvent_ActivityTaskScheduledEventAttributes{ActivityTaskScheduledEventAttributes: &historypb.ActivityTaskScheduledEventAttributes{}},
+	}
+	timerStartedEvent := &historypb.HistoryEvent{
+		Version:    version,
+		EventId:    131,
+		Timestamp:  now.UnixNano(),
+		EventType:  enumspb.EVENT_TYPE_TIMER_STARTED,
+		Attributes: &historypb.HistoryEvent_TimerStartedEventAttributes{TimerStartedEventAttributes: &historypb.TimerStartedEventAttributes{}},
+	}
+	workflowCompletedEvent := &historypb.HistoryEvent{
+		Version:    version,
+		EventId:    132,
+		Timestamp:  now.UnixNano(),
+		EventType:  enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_COMPLETED,
+		Attributes: &historypb.HistoryEvent_WorkflowExecutionCompletedEventAttributes{WorkflowExecutionCompletedEventAttributes: &historypb.WorkflowExecutionCompletedEventAttributes{}},
+	}
+	events := s.toHistory(activityScheduledEvent, timerStartedEvent, workflowCompletedEvent)
+
+	executionInfo := &persistence.WorkflowExecutionInfo{}
+	s.mockMutableState.EXPECT().GetExecutionInfo().Return(executionInfo).AnyTimes()
+	for _, event := range events {
+		s.mockMutableState.EXPECT().UpdateReplicationStateVersion(event.GetVersion(), true).Times(1)
+	}
+	s.mockMutableState.EXPECT().UpdateReplicationStateLastEventID(version, workflowCompletedEvent.GetEventId()).Times(len(events))
+	s.mockMutableState.EXPECT().ReplicateActivityTaskScheduledEvent(activityScheduledEvent.GetEventId(), activityScheduledEvent).Return(&persistence.ActivityInfo{}, nil).Times(1)
+	s.mockMutableState.EXPECT().ReplicateTimerStartedEvent(timerStartedEvent).Return(&persistenceblobs.TimerInfo{}, nil).Times(1)
+	s.mockMutableState.EXPECT().ReplicateWorkflowExecutionCompletedEvent(activityScheduledEvent.GetEventId(), workflowCompletedEvent).Return(nil).Times(1)
+	s.mockMutableState.EXPECT().ClearStickyness().Times(1)
+	s.mockMutableState.EXPECT().SetHistoryBuilder(newHistoryBuilderFromEvents(events, s.logger)).Times(1)
+	// no expectation is set on the task generator, any invocation will fail the test
+
+	stateBuilder := newStateBuilderWithOptions(
+		s.mockShard,
+		s.logger,
+		s.mockMutableState,
+		func(mutableState mutableState) mutableStateTaskGenerator {
+			return s.mockTaskGenerator
+		},
+		transactionPolicyPassive,
+		stateBuilderOptions{SkipTaskGeneration: true},
+	)
+	_, err := stateBuilder.applyEvents(testNamespaceID, requestID, execution, events, nil, false)
+	s.Nil(err)
+	s.Equal(activityScheduledEvent.GetEventId(), executionInfo.LastFirstEventID)
+	s.Equal(workflowCompletedEvent.GetEventId()+1, executionInfo.NextEventID)
+}
+
+func (s *stateBuilderSuite) TestApplyEventsAndCollectTasks() {
+	version := int64(1)
+	requestID := uuid.New()
+	execution := commonpb.WorkflowExecution{
+		WorkflowId: "some random workflow ID",
+		RunId:      testRunID,
+	}
+
+	now := time.Now()
+	activityScheduledEvent := &historypb.HistoryEvent{
+		Version:    version,
+		EventId:    130,
+		Timestamp:  now.UnixNano(),
+		EventType:  enumspb.EVENT_TYPE_ACTIVITY_TASK_SCHEDULED,
+		Attributes: &historypb.HistoryEvent_ActivityTaskScheduledEventAttributes{ActivityTaskScheduledEventAttributes: &historypb.ActivityTaskScheduledEventAttributes{}},
+	}
+	timerStartedEvent := &historypb.HistoryEvent{
+		Version:    version,
+		EventId:    131,
+		Timestamp:  now.UnixNano(),
+		EventType:  enumspb.EVENT_TYPE_TIMER_STARTED,
+		Attributes: &historypb.HistoryEvent_TimerStartedEventAttributes{TimerStartedEventAttributes: &historypb.TimerStartedEventAttributes{}},
+	}
+	events := s.toHistory(activityScheduledEvent, timerStartedEvent)
+
+	activityTask := &persistence.ActivityTask{
+		ScheduleID: activityScheduledEvent.GetEventId(),
+		Version:    version,
+	}
+	activityTimerTask := &persistence.ActivityTimeoutTask{
+		EventID: activityScheduledEvent.GetEventId(),
+		Version: version,
+	}
+	userTimerTask := &persistence.UserTimerTask{
+		EventID: timerStartedEvent.GetEventId(),
+		Version: version,
+	}
+
+	var taskMutableState mutableState
+	stateBuilder := newStateBuilder(
+		s.mockShard,
+		s.logger,
+		s.mockMutableState,
+		func(mutableState mutableState) mutableStateTaskGenerator {
+			taskMutableState = mutableState
+			return s.mockTaskGenerator
+		},
+		transactionPolicyPassive,
+	)
+
+	s.mockMutableState.EXPECT().GetExecutionInfo().Return(&persistence.WorkflowExecutionInfo{}).AnyTimes()
+	s.mockMutableState.EXPECT().ReplicateActivityTaskScheduledEvent(activityScheduledEvent.GetEventId(), activityScheduledEvent).Return(&persistence.ActivityInfo{}, nil).Times(1)
+	s.mockMutableState.EXPECT().ReplicateTimerStartedEvent(timerStartedEvent).Return(&persistenceblobs.TimerInfo{}, nil).Times(1)
+	s.mockMutableState.EXPECT().ClearStickyness().Times(1)
+	s.mockMutableState.EXPECT().UpdateReplicationStateVersion(version, true).Times(len(events))
+	s.mockMutableState.EXPECT().UpdateReplicationStateLastEventID(version, timerStartedEvent.GetEventId()).Times(len(events))
+	s.mockMutableState.EXPECT().SetHistoryBuilder(newHistoryBuilderFromEvents(events, s.logger)).Times(1)
+	s.mockMutableState.EXPECT().AddTransferTasks(activityTask).Times(1)
+	s.mockMutableState.EXPECT().AddTimerTasks(activityTimerTask).Times(1)
+	s.mockMutableState.EXPECT().AddTimerTasks(userTimerTask).Times(1)
+	s.mockTaskGenerator.EXPECT().generateActivityTransferTasks(
+		stateBuilder.unixNanoToTime(activityScheduledEvent.GetTimestamp()),
+		activityScheduledEvent,
+	).DoAndReturn(func(_ time.Time, _ *historypb.HistoryEvent) error {
+		taskMutableState.AddTransferTasks(activityTask)
+		return nil
+	}).Times(1)
+	s.mockTaskGenerator.EXPECT().generateActivityTimerTasks(
+		stateBuilder.unixNanoToTime(timerStartedEvent.GetTimestamp()),
+	).DoAndReturn(func(_ time.Time) error {
+		taskMutableState.AddTimerTasks(activityTimerTask)
+		return nil
+	}).Times(1)
+	s.mockTaskGenerator.EXPECT().generateUserTimerTasks(
+		stateBuilder.unixNanoToTime(timerStartedEvent.GetTimestamp()),
+	).DoAndReturn(func(_ time.Time) error {
+		taskMutableState.AddTimerTasks(userTimerTask)
+		return nil
+	}).Times(1)
+
+	_, tasks, err := stateBuilder.applyEventsAndCollectTasks(testNamespaceID, requestID, execution, events, nil, false)
+	s.Nil(err)
+	s.Equal(map[int64][]persistence.Task{
+		activityScheduledEvent.GetEventId(): {activityTask},
+	}, tasks.TransferTasks)
+	// activity / user timer tasks are generated at the end of the batch, but keyed by their own event ID
+	s.Equal(map[int64][]persistence.Task{
+		activityScheduledEvent.GetEventId(): {activityTimerTask},
+		timerStartedEvent.GetEventId():      {userTimerTask},
+	}, tasks.TimerTasks)
+	s.Nil(stateBuilder.taskCollector)
+}
+
+func (s *stateBuilderSuite) TestApplyEvents_TimerTasks_EventTimestampGap() {
+	version := int64(1)
+	requestID := uuid.New()
+	execution := commonpb.WorkflowExecution{
+		WorkflowId: "some random workflow ID",
+		RunId:      testRunID,
+	}
+
+	timerStartedTime := time.Now()
+	activityScheduledTime := timerStartedTime.Add(24 * time.Hour)
+	timerFireTimeout := 10 * time.Second
+	scheduleToStartTimeoutSeconds := int32(10)
+	scheduleToCloseTimeoutSeconds := int32(20)
+
+	timerStartedEvent := &historypb.HistoryEvent{
+		Version:   version,
+		EventId:   130,
+		Timestamp: timerStartedTime.UnixNano(),
+		EventType: enumspb.EVENT_TYPE_TIMER_STARTED,
+		Attributes: &historypb.HistoryEvent_TimerStartedEventAttributes{TimerStartedEventAttributes: &historypb.TimerStartedEventAttributes{
+			TimerId:                   "some random timer ID",
+			StartToFireTimeoutSeconds: int64(timerFireTimeout.Seconds()),
+		}},
+	}
+	activityScheduledEvent := &historypb.HistoryEvent{
+		Version:   version,
+		EventId:   131,
+		Timestamp: activityScheduledTime.UnixNano(),
+		EventType: enumspb.EVENT_TYPE_ACTIVITY_TASK_SCHEDULED,
+		Attributes: &historypb.HistoryEvent_ActivityTaskScheduledEventAttributes{ActivityTaskScheduledEventAttributes: &historypb.ActivityTaskScheduledEventAttributes{
+			ScheduleToStartTimeoutSeconds: scheduleToStartTimeoutSeconds,
+			ScheduleToCloseTimeoutSeconds: scheduleToCloseTimeoutSeconds,
+		}},
+	}
+	events := s.toHistory(timerStartedEvent, activityScheduledEvent)
+
+	// mimic the replicate calls, which record the timestamp of their own event
+	timerExpiryTime, err := types.TimestampProto(timerStartedTime.Add(timerFireTimeout))
+	s.NoError(err)
+	timerInfo := &persistenceblobs.TimerInfo{
+		Version:    version,
+		TimerId:    "some random timer ID",
+		StartedId:  timerStartedEvent.GetEventId(),
+		ExpiryTime: timerExpiryTime,
+		TaskStatus: timerTaskStatusNone,
+	}
+	activityInfo := &persistence.ActivityInfo{
+		Version:                version,
+		ScheduleID:             activityScheduledEvent.GetEventId(),
+		ScheduledTime:          activityScheduledTime,
+		StartedID:              common.EmptyEventID,
+		NamespaceID:            testNamespaceID,
+		ScheduleToStartTimeout: scheduleToStartTimeoutSeconds,
+		ScheduleToCloseTimeout: scheduleToCloseTimeoutSeconds,
+		TimerTaskStatus:        timerTaskStatusNone,
+	}
+
+	s.mockMutableState.EXPECT().GetExecutionInfo().Return(&persistence.WorkflowExecutionInfo{}).AnyTimes()
+	s.mockMutableState.EXPECT().GetCurrentVersion().Return(version).AnyTimes()
+	s.mockMutableState.EXPECT().UpdateReplicationStateVersion(version, true).Times(len(events))
+	s.mockMutableState.EXPECT().UpdateReplicationStateLastEventID(version, activityScheduledEvent.GetEventId()).Times(len(events))
+	s.mockMutableState.EXPECT().ClearStickyness().Times(1)
+	s.mockMutableState.EXPECT().SetHistoryBuilder(newHistoryBuilderFromEvents(events, s.logger)).Times(1)
+	s.mockMutableState.EXPECT().ReplicateTimerStartedEvent(timerStartedEvent).Return(timerInfo, nil).Times(1)
+	s.mockMutableState.EXPECT().ReplicateActivityTaskScheduledEvent(activityScheduledEvent.GetEventId(), activityScheduledEvent).Return(activityInfo, nil).Times(1)
+	s.mockMutableState.EXPECT().GetPendingTimerInfos().Return(map[string]*persistenceblobs.TimerInfo{timerInfo.TimerId: timerInfo}).AnyTimes()
+	s.mockMutableState.EXPECT().GetUserTimerInfoByEventID(timerInfo.StartedId).Return(timerInfo, true).AnyTimes()
+	s.mockMutableState.EXPECT().UpdateUserTimer(gomock.Any()).Return(nil).Times(1)
+	s.mockMutableState.EXPECT().GetPendingActivityInfos().Return(map[int64]*persistence.ActivityInfo{activityInfo.ScheduleID: activityInfo}).AnyTimes()
+	s.mockMutableState.EXPECT().GetActivityInfo(activityInfo.ScheduleID).Return(activityInfo, true).AnyTimes()
+	s.mockMutableState.EXPECT().UpdateActivity(gomock.Any()).Return(nil).Times(1)
+	s.mockMutableState.EXPECT().AddTransferTasks(gomock.Any()).Times(1)
+
+	var timerTasks []persistence.Task
+	s.mockMutableState.EXPECT().AddTimerTasks(gomock.Any()).Do(func(tasks ...persistence.Task) {
+		timerTasks = append(timerTasks, tasks...)
+	}).Times(2)
+
+	stateBuilder := newStateBuilder(
+		s.mockShard,
+		s.logger,
+		s.mockMutableState,
+		func(mutableState mutableState) mutableStateTaskGenerator {
+			return newMutableStateTaskGenerator(s.mockNamespaceCache, s.logger, mutableState)
+		},
+		transactionPolicyPassive,
+	)
+	_, err = stateBuilder.applyEvents(testNamespaceID, requestID, execution, events, nil, false)
+	s.NoError(err)
+
+	s.Len(timerTasks, 2)
+	for _, task := range timerTasks {
+		switch task := task.(type) {
+		case *persistence.ActivityTimeoutTask:
+			s.Equal(activityInfo.ScheduleID, task.EventID)
+			s.Equal(int(timerTypeScheduleToStart), task.TimeoutType)
+			s.True(activityScheduledTime.Add(time.Duration(scheduleToStartTimeoutSeconds) * time.Second).Equal(task.VisibilityTimestamp))
+		case *persistence.UserTimerTask:
+			s.Equal(timerInfo.StartedId, task.EventID)
+			s.True(timerStartedTime.Add(timerFireTimeout).Equal(task.VisibilityTimestamp))
+		default:
+			s.Fail("unexpected timer task type", "%T", task)
+		}
+	}
+}
+
+func (s *stateBuilderSuite) TestApplyEvents_EventProcessedCallback() {
+	version := int64(1)
+	requestID := uuid.New()
+	execution := commonpb.WorkflowExecution{
+		WorkflowId: "some random workflow ID",
+		RunId:      testRunID,
+	}
+
+	now := time.Now()
+	activityScheduledEvent := &historypb.HistoryEvent{
+		Version:    version,
+		EventId:    130,
+		Timestamp:  now.UnixNano(),
+		EventType:  enumspb.EVENT_TYPE_ACTIVITY_TASK_SCHEDULED,
+		Attributes: &historypb.HistoryEvent_ActivityTaskScheduledEventAttributes{ActivityTaskScheduledEventAttributes: &historypb.ActivityTaskScheduledEventAttributes{}},
+	}
+	timerStartedEvent := &historypb.HistoryEvent{
+		Version:    version,
+		EventId:    131,
+		Timestamp:  now.UnixNano(),
+		EventType:  enumspb.EVENT_TYPE_TIMER_STARTED,
+		Attributes: &historypb.HistoryEvent_TimerStartedEventAttributes{TimerStartedEventAttributes: &historypb.TimerStartedEventAttributes{}},
+	}
+	workflowCompletedEvent := &historypb.HistoryEvent{
+		Version:    version,
+		EventId:    132,
+		Timestamp:  now.UnixNano(),
+		EventType:  enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_COMPLETED,
+		Attributes: &historypb.HistoryEvent_WorkflowExecutionCompletedEventAttributes{WorkflowExecutionCompletedEventAttributes: &historypb.WorkflowExecutionCompletedEventAttributes{}},
+	}
+	events := s.toHistory(activityScheduledEvent, timerStartedEvent, workflowCompletedEvent)
+
+	type processedEvent struct {
+		eventType enumspb.EventType
+		eventID   int64
+		err       error
+	}
+	var processedEvents []processedEvent
+	stateBuilder := newStateBuilderWithOptions(
+		s.mockShard,
+		s.logger,
+		s.mockMutableState,
+		func(mutableState mutableState) mutableStateTaskGenerator {
+			return s.mockTaskGenerator
+		},
+		transactionPolicyPassive,
+		stateBuilderOptions{
+			EventProcessedCallback: func(eventType enumspb.EventType, eventID int64, err error) {
+				processedEvents = append(processedEvents, processedEvent{eventType: eventType, eventID: eventID, err: err})
+			},
+		},
+	)
+
+	s.mockMutableState.EXPECT().GetExecutionInfo().Return(&persistence.WorkflowExecutionInfo{}).AnyTimes()
+	s.mockMutableState.EXPECT().ClearStickyness().Times(1)
+	s.mockMutableState.EXPECT().UpdateReplicationStateVersion(version, true).Times(len(events))
+	s.mockMutableState.EXPECT().UpdateReplicationStateLastEventID(version, workflowCompletedEvent.GetEventId()).Times(len(events))
+	s.mockMutableState.EXPECT().SetHistoryBuilder(newHistoryBuilderFromEvents(events, s.logger)).Times(1)
+	s.mockMutableState.EXPECT().ReplicateActivityTaskScheduledEvent(activityScheduledEvent.GetEventId(), activityScheduledEvent).Return(&persistence.ActivityInfo{}, nil).Times(1)
+	s.mockMutableState.EXPECT().ReplicateTimerStartedEvent(timerStartedEvent).Return(&persistenceblobs.TimerInfo{}, nil).Times(1)
+	s.mockMutableState.EXPECT().ReplicateWorkflowExecutionCompletedEvent(activityScheduledEvent.GetEventId(), workflowCompletedEvent).Return(nil).Times(1)
+	s.mockTaskGenerator.EXPECT().generateActivityTransferTasks(
+		stateBuilder.unixNanoToTime(activityScheduledEvent.GetTimestamp()),
+		activityScheduledEvent,
+	).Return(nil).Times(1)
+	s.mockTaskGenerator.EXPECT().generateWorkflowCloseTasks(
+		stateBuilder.unixNanoToTime(workflowCompletedEvent.GetTimestamp()),
+	).Return(nil).Times(1)
+	s.mockTaskGenerator.EXPECT().generateActivityTimerTasks(
+		stateBuilder.unixNanoToTime(workflowCompletedEvent.GetTimestamp()),
+	).Return(nil).Times(1)
+	s.mockTaskGenerator.EXPECT().generateUserTimerTasks(
+		stateBuilder.unixNanoToTime(workflowCompletedEvent.GetTimestamp()),
+	).Return(nil).Times(1)
+
+	_, err := stateBuilder.applyEvents(testNamespaceID, requestID, execution, events, nil, false)
+	s.NoError(err)
+	s.Equal([]processedEvent{
+		{eventType: enumspb.EVENT_TYPE_ACTIVITY_TASK_SCHEDULED, eventID: activityScheduledEvent.GetEventId()},
+		{eventType: enumspb.EVENT_TYPE_TIMER_STARTED, eventID: timerStartedEvent.GetEventId()},
+		{eventType: enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_COMPLETED, eventID: workflowCompletedEvent.GetEventId()},
+	}, processedEvents)
+}
+
+func (s *stateBuilderSuite) TestApplyEvents_EventProcessedCallback_Error() {
+	version := int64(1)
+	requestID := uuid.New()
+	execution := commonpb.WorkflowExecution{
+		WorkflowId: "some random workflow ID",
+		RunId:      testRunID,
+	}
+
+	now := time.Now()
+	timerStartedEvent := &historypb.HistoryEvent{
+		Version:    version,
+		EventId:    130,
+		Timestamp:  now.UnixNano(),
+		EventType:  enumspb.EVENT_TYPE_TIMER_STARTED,
+		Attributes: &historypb.HistoryEvent_TimerStartedEventAttributes{TimerStartedEventAttributes: &historypb.TimerStartedEventAttributes{}},
+	}
+	timerFiredEvent := &historypb.HistoryEvent{
+		Version:    version,
+		EventId:    131,
+		Timestamp:  now.UnixNano(),
+		EventType:  enumspb.EVENT_TYPE_TIMER_FIRED,
+		Attributes: &historypb.HistoryEvent_TimerFiredEventAttributes{TimerFiredEventAttributes: &historypb.TimerFiredEventAttributes{}},
+	}
+	events := s.toHistory(timerStartedEvent, timerFiredEvent)
+
+	var processedEventIDs []int64
+	var processedErrs []error
+	stateBuilder := newStateBuilderWithOptions(
+		s.mockShard,
+		s.logger,
+		s.mockMutableState,
+		func(mutableState mutableState) mutableStateTaskGenerator {
+			return s.mockTaskGenerator
+		},
+		transactionPolicyPassive,
+		stateBuilderOptions{
+			EventProcessedCallback: func(eventType enumspb.EventType, eventID int64, err error) {
+				processedEventIDs = append(processedEventIDs, eventID)
+				processedErrs = append(processedErrs, err)
+			},
+		},
+	)
+
+	replicateErr := serviceerror.NewInternal("some random replicate error")
+	s.mockMutableState.EXPECT().GetExecutionInfo().Return(&persistence.WorkflowExecutionInfo{}).AnyTimes()
+	s.mockMutableState.EXPECT().ClearStickyness().Times(1)
+	s.mockMutableState.EXPECT().UpdateReplicationStateVersion(version, true).Times(len(events))
+	s.mockMutableState.EXPECT().UpdateReplicationStateLastEventID(version, timerFiredEvent.GetEventId()).Times(len(events))
+	s.mockMutableState.EXPECT().ReplicateTimerStartedEvent(timerStartedEvent).Return(&persistenceblobs.TimerInfo{}, nil).Times(1)
+	s.mockMutableState.EXPECT().ReplicateTimerFiredEvent(timerFiredEvent).Return(replicateErr).Times(1)
+
+	_, err := stateBuilder.applyEvents(testNamespaceID, requestID, execution, events, nil, false)
+	s.Equal(replicateErr, err)
+	s.Equal([]int64{timerStartedEvent.GetEventId(), timerFiredEvent.GetEventId()}, processedEventIDs)
+	s.Equal([]error{nil, replicateErr}, processedErrs)
+}
+
+func (s *stateBuilderSuite) TestApplyEvents_DecisionTaskCompleted_ClearsTransientDecision() {
+	version := int64(1)
+	execution := commonpb.WorkflowExecution{
+		WorkflowId: "some random workflow ID",
+		RunId:      testRunID,
+	}
+	now := time.Now()
+	tasklist := "some random tasklist"
+	taskTimeoutSeconds := int32(11)
+
+	newEvent := func(eventID int64, eventType enumspb.EventType) *historypb.HistoryEvent {
+		return &historypb.HistoryEvent{
+			Version:   version,
+			EventId:   eventID,
+			Timestamp: now.UnixNano(),
+			EventType: eventType,
+		}
+	}
+	workflowStartedEvent := newEvent(1, enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_STARTED)
+	workflowStartedEvent.Attributes = &historypb.HistoryEvent_WorkflowExecutionStartedEventAttributes{WorkflowExecutionStartedEventAttributes: &historypb.WorkflowExecutionStartedEventAttributes{
+		WorkflowType:                    &commonpb.WorkflowType{Name: "some random workflow type"},
+		TaskList:                        &tasklistpb.TaskList{Name: tasklist},
+		WorkflowExecutionTimeoutSeconds: 110,
+		WorkflowRunTimeoutSeconds:       110,
+		WorkflowTaskTimeoutSeconds:      taskTimeoutSeconds,
+	}}
+	decisionScheduledEvent := newEvent(2, enumspb.EVENT_TYPE_DECISION_TASK_SCHEDULED)
+	decisionScheduledEvent.Attributes = &historypb.HistoryEvent_DecisionTaskScheduledEventAttributes{DecisionTaskScheduledEventAttributes: &historypb.DecisionTaskScheduledEventAttributes{
+		TaskList:                   &tasklistpb.TaskList{Name: tasklist},
+		StartToCloseTimeoutSeconds: taskTimeoutSeconds,
+	}}
+	decisionStartedEvent := newEvent(3, enumspb.EVENT_TYPE_DECISION_TASK_STARTED)
+	decisionStartedEvent.Attributes = &historypb.HistoryEvent_DecisionTaskStartedEventAttributes{DecisionTaskStartedEventAttributes: &historypb.DecisionTaskStartedEventAttributes{
+		ScheduledEventId: decisionScheduledEvent.GetEventId(),
+		RequestId:        uuid.New(),
+	}}
+	decisionFailedEvent := newEvent(4, enumspb.EVENT_TYPE_DECISION_TASK_FAILED)
+	decisionFailedEvent.Attributes = &historypb.HistoryEvent_DecisionTaskFailedEventAttributes{DecisionTaskFailedEventAttributes: &historypb.DecisionTaskFailedEventAttributes{
+		ScheduledEventId: decisionScheduledEvent.GetEventId(),
+		StartedEventId:   decisionStartedEvent.GetEventId(),
+		Cause:            enumspb.DECISION_TASK_FAILED_CAUSE_WORKFLOW_WORKER_UNHANDLED_FAILURE,
+	}}
+	transientDecisionScheduledEvent := newEvent(5, enumspb.EVENT_TYPE_DECISION_TASK_SCHEDULED)
+	transientDecisionScheduledEvent.Attributes = &historypb.HistoryEvent_DecisionTaskScheduledEventAttributes{DecisionTaskScheduledEventAttributes: &historypb.DecisionTaskScheduledEventAttributes{
+		TaskList:                   &tasklistpb.TaskList{Name: tasklist},
+		StartToCloseTimeoutSeconds: taskTimeoutSeconds,
+		Attempt:                    1,
+	}}
+	transientDecisionStartedEvent := newEvent(6, enumspb.EVENT_TYPE_DECISION_TASK_STARTED)
+	transientDecisionStartedEvent.Attributes = &historypb.HistoryEvent_DecisionTaskStartedEventAttributes{DecisionTaskStartedEventAttributes: &historypb.DecisionTaskStartedEventAttributes{
+		ScheduledEventId: transientDecisionScheduledEvent.GetEventId(),
+		RequestId:        uuid.New(),
+	}}
+	decisionCompletedEvent := newEvent(7, enumspb.EVENT_TYPE_DECISION_TASK_COMPLETED)
+	decisionCompletedEvent.Attributes = &historypb.HistoryEvent_DecisionTaskCompletedEventAttributes{DecisionTaskCompletedEventAttributes: &historypb.DecisionTaskCompletedEventAttributes{
+		ScheduledEventId: transientDecisionScheduledEvent.GetEventId(),
+		StartedEventId:   transientDecisionStartedEvent.GetEventId(),
+	}}
+
+	s.mockClusterMetadata.EXPECT().ClusterNameForFailoverVersion(version).Return(s.sourceCluster).AnyTimes()
+	mutableState := newMutableStateBuilderWithReplicationState(
+		s.mockShard,
+		s.mockShard.GetEventsCache(),
+		s.logger,
+		testGlobalNamespaceEntry,
+	)
+	stateBuilder := newStateBuilderWithOptions(
+		s.mockShard,
+		s.logger,
+		mutableState,
+		nil,
+		transactionPolicyPassive,
+		stateBuilderOptions{SkipTaskGeneration: true},
+	)
+	applyEvents := func(events ...*historypb.HistoryEvent) {
+		_, err := stateBuilder.applyEvents(testNamespaceID, uuid.New(), execution, events, nil, false)
+		s.NoError(err)
+	}
+
+	applyEvents(workflowStartedEvent, decisionScheduledEvent)
+	applyEvents(decisionStartedEvent)
+
+	// decision failed, a transient decision is created
+	applyEvents(decisionFailedEvent)
+	s.True(mutableState.HasPendingDecision())
+	s.Equal(int64(1), mutableState.GetExecutionInfo().DecisionAttempt)
+
+	// active side writes the transient decision scheduled & started events when the decision completes
+	applyEvents(transientDecisionScheduledEvent, transientDecisionStartedEvent)
+	applyEvents(decisionCompletedEvent)
+
+	s.False(mutableState.HasPendingDecision())
+	s.False(mutableState.HasInFlightDecision())
+	executionInfo := mutableState.GetExecutionInfo()
+	s.Equal(int64(0), executionInfo.DecisionAttempt)
+	s.Equal(common.EmptyEventID, executionInfo.DecisionScheduleID)
+	s.Equal(common.EmptyEventID, executionInfo.DecisionStartedID)
+	s.Equal(transientDecisionStartedEvent.GetEventId(), executionInfo.LastProcessedEvent)
+	s.Equal(decisionCompletedEvent.GetEventId()+1, executionInfo.NextEventID)
+}
+
+func (s *stateBuilderSuite) TestApplyEvents_UnknownEventType() {
+	version := int64(1)
+	requestID := uuid.New()
+	execution := commonpb.WorkflowExecution{
+		WorkflowId: "some random workflow ID",
+		RunId:      testRunID,
+	}
+
+	now := time.Now()
+	unknownEventType := enumspb.EventType(len(enumspb.EventType_value) + 100)
+	event := &historypb.HistoryEvent{
+		Version:   version,
+		EventId:   130,
+		TaskId:    1234,
+		Timestamp: now.UnixNano(),
+		EventType: unknownEventType,
+	}
+	executionInfo := &persistence.WorkflowExecutionInfo{}
+	s.mockMutableState.EXPECT().GetExecutionInfo().Return(executionInfo).AnyTimes()
+	s.mockMutableState.EXPECT().UpdateReplicationStateVersion(event.GetVersion(), true).Times(2)
+	s.mockMutableState.EXPECT().UpdateReplicationStateLastEventID(event.GetVersion(), event.GetEventId()).Times(2)
+	s.mockMutableState.EXPECT().ClearStickyness().Times(2)
+
+	// strict by default
+	_, err := s.stateBuilder.applyEvents(testNamespaceID, requestID, execution, s.toHistory(event), nil, false)
+	s.IsType(&serviceerror.InvalidArgument{}, err)
+
+	// skip ignorable event type
+	stateBuilder := newStateBuilderWithOptions(
+		s.mockShard,
+		s.logger,
+		s.mockMutableState,
+		func(mutableState mutableState) mutableStateTaskGenerator {
+			return s.mockTaskGenerator
+		},
+		transactionPolicyPassive,
+		stateBuilderOptions{
+			IgnorableEventTypes: map[enumspb.EventType]struct{}{unknownEventType: {}},
+		},
+	)
+	s.mockTaskGenerator.EXPECT().generateActivityTimerTasks(stateBuilder.unixNanoToTime(event.GetTimestamp())).Return(nil).Times(1)
+	s.mockTaskGenerator.EXPECT().generateUserTimerTasks(stateBuilder.unixNanoToTime(event.GetTimestamp())).Return(nil).Times(1)
+	s.mockMutableState.EXPECT().SetHistoryBuilder(newHistoryBuilderFromEvents(s.toHistory(event), s.logger)).Times(1)
+
+	_, err = stateBuilder.applyEvents(testNamespaceID, requestID, execution, s.toHistory(event), nil, false)
+	s.Nil(err)
+	s.Equal(event.GetTaskId(), executionInfo.LastEventTaskID)
+	s.Equal(event.GetEventId()+1, executionInfo.NextEventID)
+}
+
+func (s *stateBuilderSuite) TestApplyEvents_InvalidEvents() {
+	requestID := uuid.New()
+	execution := commonpb.WorkflowExecution{
+		WorkflowId: "some random workflow ID",
+		RunId:      testRunID,
+	}
+	now := time.Now()
+	newEvent := func(eventID int64, version int64) *historypb.HistoryEvent {
+		return &historypb.HistoryEvent{
+			Version:    version,
+			EventId:    eventID,
+			Timestamp:  now.UnixNano(),
+			EventType:  enumspb.EVENT_TYPE_MARKER_RECORDED,
+			Attributes: &historypb.HistoryEvent_MarkerRecordedEventAttributes{MarkerRecordedEventAttributes: &historypb.MarkerRecordedEventAttributes{}},
+		}
+	}
+
+	testCases := []struct {
+		name        string
+		nextEventID int64
+		events      []*historypb.HistoryEvent
+	}{
+		{
+			name:        "gap before first event",
+			nextEventID: 130,
+			events:      s.toHistory(newEvent(132, 1), newEvent(133, 1)),
+		},
+		{
+			name:        "first event before next event ID",
+			nextEventID: 130,
+			events:      s.toHistory(newEvent(129, 1), newEvent(130, 1)),
+		},
+		{
+			name:        "gap in the middle",
+			nextEventID: 130,
+			events:      s.toHistory(newEvent(130, 1), newEvent(131, 1), newEvent(133, 1)),
+		},
+		{
+			name:        "out of order event ID",
+			nextEventID: 130,
+			events:      s.toHistory(newEvent(130, 1), newEvent(132, 1), newEvent(131, 1)),
+		},
+		{
+			name:        "duplicated event ID",
+			nextEventID: 130,
+			events:      s.toHistory(newEvent(130, 1), newEvent(130, 1)),
+		},
+		{
+			name:        "version goes backward",
+			nextEventID: 130,
+			events:      s.toHistory(newEvent(130, 2), newEvent(131, 1)),
+		},
+		{
+			name:        "version goes backward without next event ID",
+			nextEventID: 0,
+			events:      s.toHistory(newEvent(130, 2), newEvent(131, 2), newEvent(132, 1)),
+		},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			controller := gomock.NewController(s.T())
+			defer controller.Finish()
+
+			mockMutableState := NewMockmutableState(controller)
+			mockMutableState.EXPECT().GetExecutionInfo().Return(&persistence.WorkflowExecutionInfo{
+				NextEventID: tc.nextEventID,
+			}).AnyTimes()
+			stateBuilder := newStateBuilder(
+				s.mockShard,
+				s.logger,
+				mockMutableState,
+				func(mutableState mutableState) mutableStateTaskGenerator {
+					return NewMockmutableStateTaskGenerator(controller)
+				},
+				transactionPolicyPassive,
+			)
+
+			_, err := stateBuilder.applyEvents(testNamespaceID, requestID, execution, tc.events, nil, false)
+			s.IsType(&serviceerror.InvalidArgument{}, err)
+		})
+	}
+}
+
 func (s *stateBuilderSuite) TestApplyEventsNewEventsNotHandled() {
 	eventTypes := enumspb.EventType_value
 	s.Equal(43, len(eventTypes), "If you see this error, you are adding new event type. "+
@@ -700,6 +700,7 @@ func (w *workflowResetorImpl) replayHistoryEvents(
 					func(mutableState mutableState) mutableStateTaskGenerator {
 						return newMutableStateTaskGenerator(w.eng.shard.GetNamespaceCache(), w.eng.logger, mutableState)
 					},
+					transactionPolicyActive,
 				)
 			}
 
@@ -947,6 +948,7 @@ func (w *workflowResetorImpl) replicateResetEvent(
 					func(mutableState mutableState) mutableStateTaskGenerator {
 						return newMutableStateTaskGenerator(w.eng.shard.GetNamespaceCache(), w.eng.logger, mutableState)
 					},
+					transactionPolicyPassive,
 				)
 			}
 			_, retError = sBuilder.applyEvents(namespaceID, requestID, *baseExecution, events, nil, false)
@@ -94,7 +94,7 @@ func newWorkflowResetter(
 		historyV2Mgr:    shard.GetHistoryManager(),
 		historyCache:    historyCache,
 		newStateRebuilder: func() nDCStateRebuilder {
-			return newNDCStateRebuilder(shard, logger)
+			return newNDCStateRebuilder(shard, logger, transactionPolicyActive)
 		},
 		logger: logger,
 	}